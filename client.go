@@ -6,6 +6,8 @@ import (
 	"github.com/Li-giegie/node/pkg/client"
 	"github.com/Li-giegie/node/pkg/conn"
 	"github.com/Li-giegie/node/pkg/message"
+	"github.com/Li-giegie/node/pkg/middleware"
+	"github.com/Li-giegie/node/pkg/stream"
 	"net"
 )
 
@@ -13,19 +15,43 @@ type Client interface {
 	NodeId() uint32
 	// Connect 连接并异步开启服务 address 支持url格式例如 tcp://127.0.0.1:5555 = 127.0.0.1:5555，缺省协议默认tcp，config参数只能接受0个或者1个
 	Connect(address string, h client.Handler, config ...*tls.Config) (err error)
+	// ConnectAddrs 依次连接地址列表直至成功并异步开启服务，连接断开时切换到下一个地址，config参数只能接受0个或者1个
+	ConnectAddrs(addresses []string, h client.Handler, config ...*tls.Config) (err error)
 	// Start 阻塞开启服务
 	Start(conn net.Conn, h client.Handler) error
 	State() client.State
-	Send(data []byte) error
+	// Conn 当前连接，断线重连后为新的连接
+	Conn() *conn.Conn
+	// AddOnMessage 在当前实例上注册回调，Connect、Start的h为nil时生效，实例上未注册的回调使用全局 client.Default
+	AddOnMessage(fn ...client.OnMessageFunc)
+	AddOnMessageWithType(typ uint8, fn client.OnMessageFunc)
+	// SetOnMessageWithType 替换typ类型消息的全部处理器
+	SetOnMessageWithType(typ uint8, fn ...client.OnMessageFunc)
+	// RemoveOnMessageWithType 移除typ类型消息的全部处理器
+	RemoveOnMessageWithType(typ uint8) bool
+	AddOnClose(fn ...client.OnCloseFunc)
+	AddOnReconnecting(fn ...client.OnReconnectingFunc)
+	AddOnReconnected(fn ...client.OnReconnectedFunc)
+	// SetOnStream 设置流处理器，未设置时拒绝对端发起的流
+	SetOnStream(fn client.OnStreamFunc)
+	// Use 添加OnMessage中间件
+	Use(mw ...middleware.Middleware)
+	Send(data []byte, md ...message.Metadata) error
 	SendMessage(m *message.Message) error
-	SendTo(dst uint32, data []byte) error
-	SendType(typ uint8, data []byte) error
-	SendTypeTo(typ uint8, dst uint32, data []byte) error
-	Request(ctx context.Context, data []byte) (int16, []byte, error)
-	RequestTo(ctx context.Context, dst uint32, data []byte) (int16, []byte, error)
-	RequestType(ctx context.Context, typ uint8, data []byte) (int16, []byte, error)
-	RequestTypeTo(ctx context.Context, typ uint8, dst uint32, data []byte) (int16, []byte, error)
+	SendTo(dst uint32, data []byte, md ...message.Metadata) error
+	SendType(typ uint8, data []byte, md ...message.Metadata) error
+	SendTypeTo(typ uint8, dst uint32, data []byte, md ...message.Metadata) error
+	Request(ctx context.Context, data []byte, md ...message.Metadata) (int16, []byte, error)
+	RequestTo(ctx context.Context, dst uint32, data []byte, md ...message.Metadata) (int16, []byte, error)
+	RequestType(ctx context.Context, typ uint8, data []byte, md ...message.Metadata) (int16, []byte, error)
+	RequestTypeTo(ctx context.Context, typ uint8, dst uint32, data []byte, md ...message.Metadata) (int16, []byte, error)
 	RequestMessage(ctx context.Context, msg *message.Message) (int16, []byte, error)
+	// RequestResponse 同RequestMessage，返回完整的响应消息，可用于获取响应的元数据
+	RequestResponse(ctx context.Context, msg *message.Message) (int16, *message.Message, error)
+	// OpenStream 向远程节点发起流
+	OpenStream(ctx context.Context) (*stream.Stream, error)
+	// OpenStreamTo 经过远程节点向dst节点发起流
+	OpenStreamTo(ctx context.Context, dst uint32) (*stream.Stream, error)
 	CreateMessage(typ uint8, src uint32, dst uint32, data []byte) *message.Message
 	CreateMessageId() uint32
 	Close() error
@@ -36,13 +62,36 @@ type Client interface {
 
 func NewClient(c *client.Config) Client {
 	return &client.Client{
-		Id:              c.Id,
-		RemoteID:        c.RemoteId,
-		RemoteKey:       c.RemoteKey,
-		AuthTimeout:     c.AuthTimeout,
-		WriterQueueSize: c.WriterQueueSize,
-		ReaderBufSize:   c.ReaderBufSize,
-		WriterBufSize:   c.WriterBufSize,
+		Id:                   c.Id,
+		RemoteID:             c.RemoteId,
+		RemoteKey:            c.RemoteKey,
+		AuthTimeout:          c.AuthTimeout,
+		DialTimeout:          c.DialTimeout,
+		ShuffleAddresses:     c.ShuffleAddresses,
+		WriterQueueSize:      c.WriterQueueSize,
+		ReaderBufSize:        c.ReaderBufSize,
+		WriterBufSize:        c.WriterBufSize,
+		EnableReconnect:      c.EnableReconnect,
+		ReconnectBackoff:     c.ReconnectBackoff,
+		MaxReconnectAttempts: c.MaxReconnectAttempts,
+		DispatchMode:         c.DispatchMode,
+		DispatchWorkers:      c.DispatchWorkers,
+		DispatchQueueSize:    c.DispatchQueueSize,
+		DispatchOrdered:      c.DispatchOrdered,
+		PanicHandler:         c.PanicHandler,
+		CloseOnPanic:         c.CloseOnPanic,
+		HandshakeVersion:     c.HandshakeVersion,
+		Protocols:            c.Protocols,
+		MaxMsgLen:            c.MaxMsgLen,
+		Compressor:           c.Compressor,
+		CompressThreshold:    c.CompressThreshold,
+		Authenticator:        c.Authenticator,
+		Token:                c.Token,
+		HandshakeMetadata:    c.HandshakeMetadata,
+		LegacyAuth:           c.LegacyAuth,
+		Integrity:            c.Integrity,
+		StreamWindow:         c.StreamWindow,
+		StreamIdleTimeout:    c.StreamIdleTimeout,
 	}
 }
 
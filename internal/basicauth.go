@@ -10,49 +10,210 @@ import (
 
 var DefaultAuthService = new(BaseAuthService)
 
+const (
+	// HandshakeMagic v2及之后版本握手帧的第一个字节，v1请求和响应的第一个字节为ConnType，不会与之冲突
+	HandshakeMagic byte  = 0xff
+	HandshakeV1    uint8 = 1
+	HandshakeV2    uint8 = 2
+	// HandshakeVersion 当前支持的最高握手版本
+	HandshakeVersion = HandshakeV2
+)
+
+// v2握手帧为 Magic 1Byte + Version 1Byte + Length 2Byte + TLV...，每个TLV为 Type 1Byte + Length 2Byte + Value，未知的Type被忽略
+const (
+	tlvConnType uint8 = iota + 1
+	tlvSrcId
+	tlvDstId
+	tlvKey
+	tlvIntegrity
+	tlvFeatures
+	tlvProtocols
+	tlvMaxMsgLen
+	tlvCode
+	tlvKeepaliveTimeout
+	tlvKeepaliveTimeoutClose
+	tlvCodecs
+	tlvNonce
+	tlvProof
+	tlvToken
+	tlvReason
+	tlvRetryAfter
+	tlvAssignedId
+	tlvMetadata
+)
+
+// HandshakeMetadataMaxLen 握手元数据编码后的最大长度
+const HandshakeMetadataMaxLen = 4096
+
+// HandshakeTokenMaxLen 握手自定义凭据的最大长度
+const HandshakeTokenMaxLen = 4096
+
 type BaseAuthRequest struct {
 	ConnType conn.Type
 	SrcId    uint32
 	DstId    uint32
 	Key      []byte
+	// 握手版本，小于 HandshakeV2 时使用v1的定长格式，以下字段仅v2有效
+	Version uint8
+	// 请求方期望的消息完整性校验方式，v1总是 conn.IntegrityLegacy
+	Integrity conn.Integrity
+	Features  conn.Feature
+	Protocols []uint8
+	MaxMsgLen uint32
+	// 可以解压的压缩算法编号
+	Codecs []uint8
+	// 请求方随机数，不为空时使用挑战应答认证
+	Nonce []byte
+	// v2时发送静态密钥摘要，v1总是发送
+	Legacy bool
+	// 自定义凭据
+	Token []byte
+	// 请求方的元数据，例如客户端版本，每个键值对一个TLV
+	Metadata map[string]string
 }
 
 func (r *BaseAuthRequest) Len() int {
 	return 41
 }
 
-func (r *BaseAuthRequest) Encode() []byte {
+func (r *BaseAuthRequest) Encode() ([]byte, error) {
+	if r.Version >= HandshakeV2 {
+		var w tlvWriter
+		w.putUint8(tlvConnType, uint8(r.ConnType))
+		w.putUint32(tlvSrcId, r.SrcId)
+		w.putUint32(tlvDstId, r.DstId)
+		if len(r.Nonce) > 0 {
+			w.put(tlvNonce, r.Nonce)
+		} else if r.Legacy {
+			w.put(tlvKey, Hash(r.Key))
+		}
+		if len(r.Token) > 0 {
+			if err := w.put(tlvToken, r.Token); err != nil {
+				return nil, err
+			}
+		}
+		w.putUint8(tlvIntegrity, uint8(r.Integrity))
+		w.putUint32(tlvFeatures, uint32(r.Features))
+		if err := w.put(tlvProtocols, r.Protocols); err != nil {
+			return nil, err
+		}
+		w.putUint32(tlvMaxMsgLen, r.MaxMsgLen)
+		if err := w.put(tlvCodecs, r.Codecs); err != nil {
+			return nil, err
+		}
+		for k, v := range r.Metadata {
+			if len(k) > tlvMaxLen {
+				return nil, errors.ErrLengthOverflow
+			}
+			if err := w.put(tlvMetadata, append(binary.LittleEndian.AppendUint16(nil, uint16(len(k))), k+v...)); err != nil {
+				return nil, err
+			}
+		}
+		return w.frame(r.Version)
+	}
 	buf := make([]byte, r.Len())
 	buf[0] = byte(r.ConnType)
 	binary.LittleEndian.PutUint32(buf[1:5], r.SrcId)
 	binary.LittleEndian.PutUint32(buf[5:9], r.DstId)
 	copy(buf[9:], Hash(r.Key))
-	return buf
+	return buf, nil
+}
+
+// MetadataLen 元数据编码后的长度
+func MetadataLen(md map[string]string) (n int) {
+	for k, v := range md {
+		n += 3 + 2 + len(k) + len(v)
+	}
+	return n
 }
 
 func (r *BaseAuthRequest) Decode(buf []byte) (err error) {
 	if len(buf) != r.Len() {
 		return errors.New("decode bad: request length invalid")
 	}
+	r.Version = HandshakeV1
+	r.Legacy = true
 	r.ConnType = conn.Type(buf[0])
+	r.Integrity = conn.IntegrityLegacy
 	r.SrcId = binary.LittleEndian.Uint32(buf[1:5])
 	r.DstId = binary.LittleEndian.Uint32(buf[5:9])
 	r.Key = buf[9:]
 	return nil
 }
 
+// decodeTLV 解析v2请求
+func (r *BaseAuthRequest) decodeTLV(version uint8, payload []byte) error {
+	r.Version = version
+	return rangeTLV(payload, func(typ uint8, v []byte) bool {
+		switch typ {
+		case tlvConnType:
+			r.ConnType = conn.Type(getUint8(v))
+		case tlvSrcId:
+			r.SrcId = getUint32(v)
+		case tlvDstId:
+			r.DstId = getUint32(v)
+		case tlvKey:
+			r.Key = v
+			r.Legacy = true
+		case tlvIntegrity:
+			r.Integrity = conn.Integrity(getUint8(v))
+		case tlvFeatures:
+			r.Features = conn.Feature(getUint32(v))
+		case tlvProtocols:
+			r.Protocols = v
+		case tlvMaxMsgLen:
+			r.MaxMsgLen = getUint32(v)
+		case tlvCodecs:
+			r.Codecs = v
+		case tlvNonce:
+			r.Nonce = v
+		case tlvToken:
+			r.Token = v
+		case tlvMetadata:
+			if len(v) < 2 || len(v) < 2+int(binary.LittleEndian.Uint16(v)) {
+				break
+			}
+			if r.Metadata == nil {
+				r.Metadata = make(map[string]string)
+			}
+			n := 2 + int(binary.LittleEndian.Uint16(v))
+			r.Metadata[string(v[2:n])] = string(v[n:])
+		}
+		return true
+	})
+}
+
 type BaseAuthService struct {
 }
 
 func (s *BaseAuthService) Request(w io.Writer, req *BaseAuthRequest) (err error) {
-	_, err = w.Write(req.Encode())
+	b, err := req.Encode()
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(b)
 	return err
 }
 
+// ReadRequest 读取认证请求，根据第一个字节区分v1和v2
 func (s *BaseAuthService) ReadRequest(r io.Reader, timeout time.Duration) (req *BaseAuthRequest, err error) {
+	deadline := time.Now().Add(timeout)
 	req = new(BaseAuthRequest)
 	buf := make([]byte, req.Len())
-	if err = ReadFull(r, timeout, buf); err != nil {
+	if err = ReadFull(r, timeout, buf[:1]); err != nil {
+		return nil, err
+	}
+	if buf[0] == HandshakeMagic {
+		version, payload, err := readFrame(r, time.Until(deadline))
+		if err != nil {
+			return nil, err
+		}
+		if err = req.decodeTLV(version, payload); err != nil {
+			return nil, err
+		}
+		return req, nil
+	}
+	if err = ReadFull(r, time.Until(deadline), buf[1:]); err != nil {
 		return nil, err
 	}
 	if err = req.Decode(buf); err != nil {
@@ -69,6 +230,10 @@ const (
 	BaseAuthResponseCodeInvalidKey
 	BaseAuthResponseCodeSrcIdExists
 	BaseAuthResponseCodeSuccess
+	BaseAuthResponseCodeLegacyAuthRejected
+	BaseAuthResponseCodeRejected
+	BaseAuthResponseCodeCertMismatch
+	BaseAuthResponseCodeServerBusy
 )
 
 func (b BaseAuthResponseCode) String() string {
@@ -83,13 +248,21 @@ func (b BaseAuthResponseCode) String() string {
 		return "src id exists"
 	case BaseAuthResponseCodeSuccess:
 		return "success"
+	case BaseAuthResponseCodeLegacyAuthRejected:
+		return "legacy auth rejected"
+	case BaseAuthResponseCodeRejected:
+		return "rejected"
+	case BaseAuthResponseCodeCertMismatch:
+		return "certificate mismatch"
+	case BaseAuthResponseCodeServerBusy:
+		return "server busy"
 	default:
 		return "invalid code"
 	}
 }
 
 func (b BaseAuthResponseCode) Valid() error {
-	if b >= BaseAuthResponseCodeInvalidSrcId && b <= BaseAuthResponseCodeSuccess {
+	if b >= BaseAuthResponseCodeInvalidSrcId && b <= BaseAuthResponseCodeServerBusy {
 		return nil
 	}
 	return errors.New("invalid code")
@@ -101,27 +274,77 @@ type BaseAuthResponse struct {
 	MaxMsgLen             uint32
 	KeepaliveTimeout      time.Duration
 	KeepaliveTimeoutClose time.Duration
+	// 握手版本，与请求的版本一致，以下字段仅v2有效
+	Version uint8
+	// 协商后的消息完整性校验方式，v1总是 conn.IntegrityLegacy
+	Integrity conn.Integrity
+	Features  conn.Feature
+	Protocols []uint8
+	Codecs    []uint8
+	// 自定义凭据
+	Token []byte
+	// 拒绝原因，由 Authenticator 返回
+	Reason string
+	// Code为 BaseAuthResponseCodeServerBusy 时建议的重试间隔，0为未指定
+	RetryAfter time.Duration
+	// 请求方节点Id为0时服务端为其分配的节点Id，0为未分配
+	AssignedId uint32
 }
 
 func (r *BaseAuthResponse) Len() int {
 	return 22
 }
 
-func (r *BaseAuthResponse) Encode() []byte {
+func (r *BaseAuthResponse) Encode() ([]byte, error) {
+	if r.Version >= HandshakeV2 {
+		var w tlvWriter
+		w.putUint8(tlvCode, uint8(r.Code))
+		w.putUint8(tlvConnType, uint8(r.ConnType))
+		w.putUint8(tlvIntegrity, uint8(r.Integrity))
+		w.putUint32(tlvMaxMsgLen, r.MaxMsgLen)
+		w.putUint64(tlvKeepaliveTimeout, uint64(r.KeepaliveTimeout))
+		w.putUint64(tlvKeepaliveTimeoutClose, uint64(r.KeepaliveTimeoutClose))
+		w.putUint32(tlvFeatures, uint32(r.Features))
+		if err := w.put(tlvProtocols, r.Protocols); err != nil {
+			return nil, err
+		}
+		if err := w.put(tlvCodecs, r.Codecs); err != nil {
+			return nil, err
+		}
+		if len(r.Token) > 0 {
+			if err := w.put(tlvToken, r.Token); err != nil {
+				return nil, err
+			}
+		}
+		if r.Reason != "" {
+			if err := w.put(tlvReason, []byte(r.Reason)); err != nil {
+				return nil, err
+			}
+		}
+		if r.RetryAfter > 0 {
+			w.putUint64(tlvRetryAfter, uint64(r.RetryAfter))
+		}
+		if r.AssignedId > 0 {
+			w.putUint32(tlvAssignedId, r.AssignedId)
+		}
+		return w.frame(r.Version)
+	}
 	buf := make([]byte, r.Len())
 	buf[0] = byte(r.ConnType)
 	buf[1] = byte(r.Code)
 	binary.LittleEndian.PutUint32(buf[2:6], r.MaxMsgLen)
 	binary.LittleEndian.PutUint64(buf[6:14], uint64(r.KeepaliveTimeout))
 	binary.LittleEndian.PutUint64(buf[14:], uint64(r.KeepaliveTimeoutClose))
-	return buf
+	return buf, nil
 }
 
 func (r *BaseAuthResponse) Decode(buf []byte) (err error) {
 	if len(buf) != 22 {
 		return errors.New("decode bad: response length invalid")
 	}
+	r.Version = HandshakeV1
 	r.ConnType = conn.Type(buf[0])
+	r.Integrity = conn.IntegrityLegacy
 	r.Code = BaseAuthResponseCode(buf[1])
 	if err = r.Code.Valid(); err != nil {
 		return err
@@ -132,16 +355,88 @@ func (r *BaseAuthResponse) Decode(buf []byte) (err error) {
 	return nil
 }
 
+// decodeTLV 解析v2响应
+func (r *BaseAuthResponse) decodeTLV(version uint8, payload []byte) error {
+	r.Version = version
+	err := rangeTLV(payload, func(typ uint8, v []byte) bool {
+		switch typ {
+		case tlvCode:
+			r.Code = BaseAuthResponseCode(getUint8(v))
+		case tlvConnType:
+			r.ConnType = conn.Type(getUint8(v))
+		case tlvIntegrity:
+			r.Integrity = conn.Integrity(getUint8(v))
+		case tlvMaxMsgLen:
+			r.MaxMsgLen = getUint32(v)
+		case tlvKeepaliveTimeout:
+			r.KeepaliveTimeout = time.Duration(getUint64(v))
+		case tlvKeepaliveTimeoutClose:
+			r.KeepaliveTimeoutClose = time.Duration(getUint64(v))
+		case tlvFeatures:
+			r.Features = conn.Feature(getUint32(v))
+		case tlvProtocols:
+			r.Protocols = v
+		case tlvCodecs:
+			r.Codecs = v
+		case tlvToken:
+			r.Token = v
+		case tlvReason:
+			r.Reason = string(v)
+		case tlvRetryAfter:
+			r.RetryAfter = time.Duration(getUint64(v))
+		case tlvAssignedId:
+			r.AssignedId = getUint32(v)
+		}
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	return r.Code.Valid()
+}
+
+// Err 认证失败时返回包含拒绝原因的错误
+func (r *BaseAuthResponse) Err() error {
+	if r.Code == BaseAuthResponseCodeSuccess {
+		return nil
+	}
+	if r.Code == BaseAuthResponseCodeServerBusy {
+		return &errors.ServerBusyError{RetryAfter: r.RetryAfter}
+	}
+	if r.Reason != "" {
+		return errors.New(r.Code.String() + ": " + r.Reason)
+	}
+	return errors.New(r.Code.String())
+}
+
 func (s *BaseAuthService) Response(w io.Writer, resp *BaseAuthResponse) (err error) {
-	_, err = w.Write(resp.Encode())
+	b, err := resp.Encode()
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(b)
 	return
 }
 
+// ReadResponse 读取认证响应，根据第一个字节区分v1和v2
 func (s *BaseAuthService) ReadResponse(r io.Reader, timeout time.Duration) (resp *BaseAuthResponse, err error) {
+	deadline := time.Now().Add(timeout)
 	resp = new(BaseAuthResponse)
 	buf := make([]byte, resp.Len())
-	err = ReadFull(r, timeout, buf)
-	if err != nil {
+	if err = ReadFull(r, timeout, buf[:1]); err != nil {
+		return nil, err
+	}
+	if buf[0] == HandshakeMagic {
+		version, payload, err := readFrame(r, time.Until(deadline))
+		if err != nil {
+			return nil, err
+		}
+		if err = resp.decodeTLV(version, payload); err != nil {
+			return nil, err
+		}
+		return resp, nil
+	}
+	if err = ReadFull(r, time.Until(deadline), buf[1:]); err != nil {
 		return nil, err
 	}
 	if err = resp.Decode(buf); err != nil {
@@ -149,3 +444,33 @@ func (s *BaseAuthService) ReadResponse(r io.Reader, timeout time.Duration) (resp
 	}
 	return resp, nil
 }
+
+// NegotiateIntegrity 取双方中较强的消息完整性校验方式，不支持的方式按 conn.IntegrityCRC32C 处理，version小于 HandshakeV2 时总是 conn.IntegrityLegacy
+func NegotiateIntegrity(version uint8, a, b conn.Integrity) conn.Integrity {
+	if version < HandshakeV2 {
+		return conn.IntegrityLegacy
+	}
+	if b > a {
+		a = b
+	}
+	if a > conn.IntegrityCRC32C {
+		a = conn.IntegrityCRC32C
+	}
+	return a
+}
+
+// NegotiateVersion 返回不高于v的本端支持的握手版本，0表示 HandshakeVersion
+func NegotiateVersion(v uint8) uint8 {
+	if v == 0 || v > HandshakeVersion {
+		return HandshakeVersion
+	}
+	return v
+}
+
+// MinMsgLen 取双方消息最大长度中较小的一个，0表示不限制
+func MinMsgLen(a, b uint32) uint32 {
+	if a == 0 || b > 0 && b < a {
+		return b
+	}
+	return a
+}
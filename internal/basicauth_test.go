@@ -0,0 +1,126 @@
+package internal
+
+import (
+	"bytes"
+	"github.com/Li-giegie/node/pkg/conn"
+	"github.com/Li-giegie/node/pkg/errors"
+	"io"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestBaseAuthRequestVersion(t *testing.T) {
+	for _, v := range []uint8{HandshakeV1, HandshakeV2} {
+		var buf bytes.Buffer
+		req := &BaseAuthRequest{ConnType: conn.TypeClient, SrcId: 1, DstId: 2, Key: []byte("key"), Legacy: true, Integrity: conn.IntegrityCRC32C, Version: v, Features: conn.Features, Protocols: []uint8{200}, MaxMsgLen: 4096, Metadata: map[string]string{"version": "1.2.0", "empty": ""}}
+		if err := DefaultAuthService.Request(&buf, req); err != nil {
+			t.Fatal(err)
+		}
+		got, err := DefaultAuthService.ReadRequest(&buf, time.Second)
+		if err != nil {
+			t.Fatal(v, err)
+		}
+		// v1不协商完整性校验方式
+		integrity := conn.IntegrityCRC32C
+		if v == HandshakeV1 {
+			integrity = conn.IntegrityLegacy
+		}
+		if got.Version != v || got.SrcId != 1 || got.DstId != 2 || got.Integrity != integrity || !bytes.Equal(got.Key, Hash(req.Key)) {
+			t.Fatalf("v%d invalid request %+v", v, got)
+		}
+		if v == HandshakeV2 && (got.Features != conn.Features || !bytes.Equal(got.Protocols, req.Protocols) || got.MaxMsgLen != 4096 || !reflect.DeepEqual(got.Metadata, req.Metadata)) {
+			t.Fatalf("v%d invalid request %+v", v, got)
+		}
+	}
+}
+
+// v1握手帧与旧版本的定长格式一致，第一个字节只有ConnType
+func TestBaseAuthV1WireFormat(t *testing.T) {
+	req := &BaseAuthRequest{ConnType: conn.TypeClient, SrcId: 1, DstId: 2, Key: []byte("key"), Integrity: conn.IntegrityCRC32C, Version: HandshakeV1}
+	want := append([]byte{byte(conn.TypeClient), 1, 0, 0, 0, 2, 0, 0, 0}, Hash(req.Key)...)
+	if b, _ := req.Encode(); !bytes.Equal(b, want) {
+		t.Fatalf("request = %x, want %x", b, want)
+	}
+	resp := &BaseAuthResponse{ConnType: conn.TypeServer, Code: BaseAuthResponseCodeSuccess, MaxMsgLen: 1024, Integrity: conn.IntegrityCRC32C, Version: HandshakeV1}
+	b, _ := resp.Encode()
+	if len(b) != 22 || b[0] != byte(conn.TypeServer) || b[1] != byte(BaseAuthResponseCodeSuccess) {
+		t.Fatalf("response = %x", b)
+	}
+	if NegotiateIntegrity(HandshakeV1, conn.IntegrityCRC32C, conn.IntegrityCRC32C) != conn.IntegrityLegacy {
+		t.Fatal("v1 must use legacy integrity")
+	}
+}
+
+// 单个TLV或整个握手帧超过长度字段的范围时返回错误而不是截断
+func TestBaseAuthRequestLengthOverflow(t *testing.T) {
+	md := make(map[string]string)
+	for i := 0; i < 20; i++ {
+		md[string(rune('a'+i))] = string(make([]byte, 4000))
+	}
+	for name, req := range map[string]*BaseAuthRequest{
+		"token":    {Version: HandshakeV2, Token: make([]byte, tlvMaxLen+1)},
+		"metadata": {Version: HandshakeV2, Metadata: md},
+	} {
+		if err := DefaultAuthService.Request(io.Discard, req); err == nil || err.Error() != errors.ErrLengthOverflow.Error() {
+			t.Fatalf("%s: err = %v, want %v", name, err, errors.ErrLengthOverflow)
+		}
+	}
+	if err := DefaultAuthService.Proof(io.Discard, HandshakeV2, make([]byte, tlvMaxLen+1)); err == nil {
+		t.Fatal("oversized proof encoded without error")
+	}
+}
+
+func TestBaseAuthResponseServerBusy(t *testing.T) {
+	var buf bytes.Buffer
+	if err := DefaultAuthService.Response(&buf, &BaseAuthResponse{Code: BaseAuthResponseCodeServerBusy, Version: HandshakeV2, RetryAfter: time.Second}); err != nil {
+		t.Fatal(err)
+	}
+	resp, err := DefaultAuthService.ReadResponse(&buf, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	busy, ok := resp.Err().(*errors.ServerBusyError)
+	if !ok || busy.RetryAfter != time.Second {
+		t.Fatalf("invalid error %v", resp.Err())
+	}
+}
+
+// serveChallenge 模拟接收方的挑战应答认证
+func serveChallenge(rw io.ReadWriter, key []byte) {
+	req, err := DefaultAuthService.ReadRequest(rw, time.Second)
+	if err != nil {
+		return
+	}
+	hashKey := Hash(key)
+	nonce := NewNonce()
+	_ = DefaultAuthService.Challenge(rw, req.Version, &BaseAuthChallenge{Nonce: nonce, Proofs: [][]byte{ServerProof(hashKey, req.Nonce, nonce, req.SrcId, req.DstId)}})
+	proof, err := DefaultAuthService.ReadProof(rw, time.Second)
+	if err != nil {
+		return
+	}
+	code := BaseAuthResponseCodeSuccess
+	if !bytes.Equal(proof, ClientProof(hashKey, req.Nonce, nonce, req.SrcId, req.DstId)) {
+		code = BaseAuthResponseCodeInvalidKey
+	}
+	_ = DefaultAuthService.Response(rw, &BaseAuthResponse{Code: code, Version: req.Version})
+}
+
+func TestHandshakeChallenge(t *testing.T) {
+	for _, remoteKey := range []string{"key", "other"} {
+		a, b := net.Pipe()
+		go serveChallenge(b, []byte(remoteKey))
+		req := &BaseAuthRequest{SrcId: 1, DstId: 2, Version: HandshakeV2, Nonce: NewNonce()}
+		resp, verified, err := DefaultAuthService.Handshake(a, req, [][]byte{[]byte("old"), []byte("key")}, time.Second)
+		if remoteKey == "key" && (err != nil || !verified || resp.Code != BaseAuthResponseCodeSuccess) {
+			t.Fatal("handshake failed", resp, err)
+		}
+		// 对端密钥不同时请求方校验对端失败
+		if remoteKey != "key" && err == nil {
+			t.Fatal("expected remote authentication failure")
+		}
+		_ = a.Close()
+		_ = b.Close()
+	}
+}
@@ -1,9 +1,11 @@
 package bufwriter
 
 import (
+	"context"
 	"errors"
 	"io"
 	"sync/atomic"
+	"time"
 )
 
 func NewWriter(w io.Writer, queueCap, bufferCap int) *Writer {
@@ -21,11 +23,13 @@ const (
 
 type Writer struct {
 	io.Writer
-	err       error
+	err       atomic.Pointer[error]
 	queue     chan []byte
+	done      chan struct{} // Close 时关闭，不关闭queue，避免并发的Write向已关闭的通道发送
 	queueCap  int
 	bufferCap int
 	state     uint32
+	pending   int64 // 已进入队列但尚未处理完成的数据块数量
 }
 
 func (w *Writer) Start() {
@@ -34,72 +38,139 @@ func (w *Writer) Start() {
 	}
 	w.state = stateStart
 	w.queue = make(chan []byte, w.queueCap)
+	w.done = make(chan struct{})
 	buf := make([]byte, w.bufferCap)
 	size := 0
 	go func() {
-		for b := range w.queue {
-			if w.err != nil {
-				continue
-			}
-			if atomic.LoadUint32(&w.state) == stateClose {
-				w.err = ErrClosed
-				continue
-			}
-			if size+len(b) >= w.bufferCap {
-				if size > 0 {
-					if _, w.err = w.Writer.Write(buf[:size]); w.err != nil {
-						continue
+		for {
+			select {
+			case b := <-w.queue:
+				size = w.process(buf, size, b)
+				atomic.AddInt64(&w.pending, -1)
+			case <-w.done:
+				for {
+					select {
+					case <-w.queue:
+						atomic.AddInt64(&w.pending, -1)
+					default:
+						return
 					}
-					size = 0
-				}
-				if len(b) >= w.bufferCap {
-					_, w.err = w.Writer.Write(b)
-				} else {
-					copy(buf[size:], b)
-					size += len(b)
 				}
-			} else {
-				copy(buf[size:], b)
-				size += len(b)
-			}
-			if len(w.queue) == 0 && size > 0 {
-				_, w.err = w.Writer.Write(buf[:size])
-				size = 0
 			}
 		}
 	}()
 }
 
+func (w *Writer) process(buf []byte, size int, b []byte) int {
+	if w.Error() != nil {
+		return size
+	}
+	if atomic.LoadUint32(&w.state) == stateClose {
+		w.setErr(ErrClosed)
+		return size
+	}
+	if size+len(b) >= w.bufferCap {
+		if size > 0 {
+			if w.write(buf[:size]) != nil {
+				return size
+			}
+			size = 0
+		}
+		if len(b) >= w.bufferCap {
+			_ = w.write(b)
+		} else {
+			copy(buf[size:], b)
+			size += len(b)
+		}
+	} else {
+		copy(buf[size:], b)
+		size += len(b)
+	}
+	if len(w.queue) == 0 && size > 0 {
+		_ = w.write(buf[:size])
+		size = 0
+	}
+	return size
+}
+
+func (w *Writer) write(b []byte) error {
+	_, err := w.Writer.Write(b)
+	if err != nil {
+		w.setErr(err)
+	}
+	return err
+}
+
+func (w *Writer) setErr(err error) {
+	w.err.CompareAndSwap(nil, &err)
+}
+
 var ErrClosed = errors.New("writer queue closed")
 
 func (w *Writer) Write(b []byte) (n int, err error) {
-	if w.err != nil {
-		return 0, w.err
+	if err = w.Error(); err != nil {
+		return 0, err
 	}
 	if atomic.LoadUint32(&w.state) == stateClose {
 		return 0, ErrClosed
 	}
-	w.queue <- b
-	return len(b), w.err
+	atomic.AddInt64(&w.pending, 1)
+	select {
+	case w.queue <- b:
+	case <-w.done:
+		atomic.AddInt64(&w.pending, -1)
+		return 0, ErrClosed
+	}
+	select {
+	case <-w.done:
+		// 与Close并发时数据可能在排空队列之后入队，取回一个数据块，保证pending最终归零
+		select {
+		case <-w.queue:
+			atomic.AddInt64(&w.pending, -1)
+		default:
+		}
+		return 0, ErrClosed
+	default:
+	}
+	return len(b), w.Error()
 }
 
 func (w *Writer) Close() error {
 	if atomic.CompareAndSwapUint32(&w.state, stateStart, stateClose) {
-		close(w.queue)
-		if len(w.queue) > 0 {
-			for range w.queue {
-			}
-		}
+		close(w.done)
 		return nil
 	} else {
 		return errors.New("writer queue already closed")
 	}
 }
 
+// Flush 阻塞直至调用前进入队列的数据全部写出，ctx结束时返回ctx.Err()
+func (w *Writer) Flush(ctx context.Context) error {
+	tick := time.NewTicker(time.Millisecond * 5)
+	defer tick.Stop()
+	for atomic.LoadInt64(&w.pending) > 0 {
+		if err := w.Error(); err != nil {
+			return err
+		}
+		if atomic.LoadUint32(&w.state) == stateClose {
+			return ErrClosed
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-tick.C:
+		}
+	}
+	return w.Error()
+}
+
 func (w *Writer) Error() error {
-	return w.err
+	if err := w.err.Load(); err != nil {
+		return *err
+	}
+	return nil
 }
 
 func (w *Writer) State() uint32 {
-	return w.state
+	return atomic.LoadUint32(&w.state)
 }
@@ -2,10 +2,12 @@ package bufwriter
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"io"
 	"strconv"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -52,3 +54,88 @@ func BenchmarkWriterGo(b *testing.B) {
 	}
 	wg.Wait()
 }
+
+type slowWriter struct {
+	l   sync.Mutex
+	buf bytes.Buffer
+}
+
+func (s *slowWriter) Write(b []byte) (int, error) {
+	time.Sleep(time.Millisecond * 2)
+	s.l.Lock()
+	defer s.l.Unlock()
+	return s.buf.Write(b)
+}
+
+func (s *slowWriter) String() string {
+	s.l.Lock()
+	defer s.l.Unlock()
+	return s.buf.String()
+}
+
+func TestWriterFlush(t *testing.T) {
+	sw := new(slowWriter)
+	wq := NewWriter(sw, 4, 8)
+	wq.Start()
+	defer wq.Close()
+	var want bytes.Buffer
+	for i := 0; i < 50; i++ {
+		b := []byte(strconv.Itoa(i) + ",")
+		want.Write(b)
+		if _, err := wq.Write(b); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := wq.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if sw.String() != want.String() {
+		t.Fatalf("flushed %q, want %q", sw.String(), want.String())
+	}
+}
+
+type blockWriter chan struct{}
+
+func (b blockWriter) Write(p []byte) (int, error) {
+	<-b
+	return len(p), nil
+}
+
+func TestWriterFlushCancel(t *testing.T) {
+	bw := make(blockWriter)
+	defer close(bw)
+	wq := NewWriter(bw, 4, 8)
+	wq.Start()
+	if _, err := wq.Write([]byte("data")); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*50)
+	defer cancel()
+	if err := wq.Flush(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("flush = %v", err)
+	}
+	_ = wq.Close()
+	if err := wq.Flush(context.Background()); err != ErrClosed {
+		t.Fatalf("flush after close = %v", err)
+	}
+}
+
+// Write在Close之前检查状态、在排空队列之后入队时不遗留未处理的数据块
+func TestWriterWriteCloseRace(t *testing.T) {
+	wq := NewWriter(io.Discard, 16, 8)
+	wq.Start()
+	_ = wq.Close()
+	// 等待写协程排空队列后退出
+	time.Sleep(time.Millisecond * 10)
+	// 模拟Close之前已经通过状态检查的Write
+	atomic.StoreUint32(&wq.state, stateStart)
+	for i := 0; i < 16; i++ {
+		if _, err := wq.Write([]byte("data")); err != ErrClosed {
+			t.Fatalf("write after close = %v", err)
+		}
+	}
+	atomic.StoreUint32(&wq.state, stateClose)
+	if n := atomic.LoadInt64(&wq.pending); n != 0 {
+		t.Fatalf("pending = %d after close", n)
+	}
+}
@@ -0,0 +1,172 @@
+package internal
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"github.com/Li-giegie/node/pkg/errors"
+	"io"
+	"time"
+)
+
+// 挑战应答认证（v2）：
+//  1. 请求方发送携带 Nonce 的请求，不再发送密钥摘要
+//  2. 接收方回复 BaseAuthChallenge：接收方的随机数及每个有效密钥的接收方证明，请求方校验证明以确认对端持有相同的密钥
+//  3. 请求方使用校验通过的密钥回复请求方证明，接收方校验后回复 BaseAuthResponse
+//
+// 证明为 HMAC-SHA256(sha256(key), label + 请求方随机数 + 接收方随机数 + SrcId + DstId)，双方的label不同，不能互相重放
+const NonceLen = 32
+
+const (
+	proofLabelServer = "node auth server"
+	proofLabelClient = "node auth client"
+)
+
+func NewNonce() []byte {
+	b := make([]byte, NonceLen)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return b
+}
+
+// ServerProof 接收方证明，hashKey为 Hash(key)
+func ServerProof(hashKey, clientNonce, serverNonce []byte, srcId, dstId uint32) []byte {
+	return proof(hashKey, proofLabelServer, clientNonce, serverNonce, srcId, dstId)
+}
+
+// ClientProof 请求方证明，hashKey为 Hash(key)
+func ClientProof(hashKey, clientNonce, serverNonce []byte, srcId, dstId uint32) []byte {
+	return proof(hashKey, proofLabelClient, clientNonce, serverNonce, srcId, dstId)
+}
+
+func proof(hashKey []byte, label string, clientNonce, serverNonce []byte, srcId, dstId uint32) []byte {
+	h := hmac.New(sha256.New, hashKey)
+	h.Write([]byte(label))
+	h.Write(clientNonce)
+	h.Write(serverNonce)
+	var ids [8]byte
+	binary.LittleEndian.PutUint32(ids[:4], srcId)
+	binary.LittleEndian.PutUint32(ids[4:], dstId)
+	h.Write(ids[:])
+	return h.Sum(nil)
+}
+
+// BaseAuthChallenge 接收方的挑战
+type BaseAuthChallenge struct {
+	Nonce []byte
+	// 密钥轮换期间每个有效密钥对应一个证明
+	Proofs [][]byte
+}
+
+func (s *BaseAuthService) Challenge(w io.Writer, version uint8, ch *BaseAuthChallenge) error {
+	var tw tlvWriter
+	if err := tw.put(tlvNonce, ch.Nonce); err != nil {
+		return err
+	}
+	for _, p := range ch.Proofs {
+		if err := tw.put(tlvProof, p); err != nil {
+			return err
+		}
+	}
+	b, err := tw.frame(version)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(b)
+	return err
+}
+
+// Proof 发送请求方证明
+func (s *BaseAuthService) Proof(w io.Writer, version uint8, proof []byte) error {
+	var tw tlvWriter
+	if err := tw.put(tlvProof, proof); err != nil {
+		return err
+	}
+	b, err := tw.frame(version)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(b)
+	return err
+}
+
+// ReadProof 读取请求方证明
+func (s *BaseAuthService) ReadProof(r io.Reader, timeout time.Duration) (proof []byte, err error) {
+	_, payload, err := readMagicFrame(r, timeout)
+	if err != nil {
+		return nil, err
+	}
+	err = rangeTLV(payload, func(typ uint8, v []byte) bool {
+		if typ == tlvProof {
+			proof = v
+			return false
+		}
+		return true
+	})
+	return proof, err
+}
+
+// Handshake 作为请求方完成认证，req.Nonce不为空时使用keys进行挑战应答认证，返回对端的认证响应，keyVerified表示对端证明持有其中一个密钥
+func (s *BaseAuthService) Handshake(rw io.ReadWriter, req *BaseAuthRequest, keys [][]byte, timeout time.Duration) (resp *BaseAuthResponse, keyVerified bool, err error) {
+	if err = s.Request(rw, req); err != nil {
+		return nil, false, err
+	}
+	if len(req.Nonce) == 0 || req.Version < HandshakeV2 {
+		resp, err = s.ReadResponse(rw, timeout)
+		return resp, false, err
+	}
+	deadline := time.Now().Add(timeout)
+	version, payload, err := readMagicFrame(rw, timeout)
+	if err != nil {
+		return nil, false, err
+	}
+	// 接收方在挑战前拒绝或不使用密钥认证时直接回复认证响应
+	var ch BaseAuthChallenge
+	var isResponse bool
+	err = rangeTLV(payload, func(typ uint8, v []byte) bool {
+		switch typ {
+		case tlvCode:
+			isResponse = true
+		case tlvNonce:
+			ch.Nonce = v
+		case tlvProof:
+			ch.Proofs = append(ch.Proofs, v)
+		}
+		return true
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	if isResponse {
+		resp = new(BaseAuthResponse)
+		if err = resp.decodeTLV(version, payload); err != nil {
+			return nil, false, err
+		}
+		return resp, false, nil
+	}
+	hashKey := matchProof(keys, ch.Proofs, req.Nonce, ch.Nonce, req.SrcId, req.DstId)
+	if hashKey == nil {
+		return nil, false, errors.ErrAuthRemoteInvalid
+	}
+	if err = s.Proof(rw, version, ClientProof(hashKey, req.Nonce, ch.Nonce, req.SrcId, req.DstId)); err != nil {
+		return nil, false, err
+	}
+	resp, err = s.ReadResponse(rw, time.Until(deadline))
+	return resp, err == nil, err
+}
+
+// matchProof 返回与任意接收方证明匹配的密钥摘要，没有匹配时返回nil
+func matchProof(keys, proofs [][]byte, clientNonce, serverNonce []byte, srcId, dstId uint32) []byte {
+	for _, key := range keys {
+		hashKey := Hash(key)
+		want := ServerProof(hashKey, clientNonce, serverNonce, srcId, dstId)
+		for _, p := range proofs {
+			if hmac.Equal(p, want) {
+				return hashKey
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+package internal
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Inflight 记录正在处理的请求的取消函数，用于响应 message.MsgType_Cancel，key为源节点Id和消息Id
+type Inflight struct {
+	l sync.Mutex
+	m map[uint64]context.CancelFunc
+}
+
+func (f *Inflight) Add(srcId, id uint32, cancel context.CancelFunc) {
+	f.l.Lock()
+	if f.m == nil {
+		f.m = make(map[uint64]context.CancelFunc)
+	}
+	f.m[uint64(srcId)<<32|uint64(id)] = cancel
+	f.l.Unlock()
+}
+
+func (f *Inflight) Remove(srcId, id uint32) {
+	f.l.Lock()
+	delete(f.m, uint64(srcId)<<32|uint64(id))
+	f.l.Unlock()
+}
+
+// Cancel 取消并移除对应的请求，请求不存在（已处理完成）时返回false
+func (f *Inflight) Cancel(srcId, id uint32) bool {
+	key := uint64(srcId)<<32 | uint64(id)
+	f.l.Lock()
+	cancel, ok := f.m[key]
+	delete(f.m, key)
+	f.l.Unlock()
+	if ok {
+		cancel()
+	}
+	return ok
+}
+
+// Context 为消息创建处理上下文，deadline为消息的截止时间（UnixNano），0表示没有截止时间，返回的cancel在处理完成后必须调用
+func (f *Inflight) Context(parent context.Context, srcId, id uint32, deadline int64) (context.Context, context.CancelFunc) {
+	var ctx context.Context
+	var cancel context.CancelFunc
+	if deadline > 0 {
+		ctx, cancel = context.WithDeadline(parent, time.Unix(0, deadline))
+	} else {
+		ctx, cancel = context.WithCancel(parent)
+	}
+	f.Add(srcId, id, cancel)
+	return ctx, func() {
+		f.Remove(srcId, id)
+		cancel()
+	}
+}
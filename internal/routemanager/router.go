@@ -17,6 +17,7 @@ type Router struct {
 	cache     map[uint32]*router.RouteEmpty
 	l         sync.RWMutex
 	rerouting []func(dst uint32) (*router.RouteEmpty, bool)
+	reachable []func(src, via uint32) bool
 }
 
 func (r *Router) AddRoute(dst, via uint32, hop uint8, unixNano int64, paths []uint32) bool {
@@ -157,6 +158,25 @@ func (r *Router) Rerouting(dst uint32) (empty *router.RouteEmpty, ok bool) {
 	return nil, false
 }
 
+func (r *Router) ReachableHandleFunc(callback func(src, via uint32) bool) {
+	r.reachable = append(r.reachable, callback)
+}
+
+func (r *Router) Reachable(src, via uint32) bool {
+	if src == via {
+		return true
+	}
+	if v, ok := r.GetRouteVia(src); ok && v == via {
+		return true
+	}
+	for _, f := range r.reachable {
+		if f(src, via) {
+			return true
+		}
+	}
+	return false
+}
+
 func (r *Router) RouteLen() int {
 	r.l.RLock()
 	defer r.l.RUnlock()
@@ -0,0 +1,117 @@
+package internal
+
+import (
+	"encoding/binary"
+	"github.com/Li-giegie/node/pkg/errors"
+	"io"
+	"time"
+)
+
+// tlvMaxLen 单个TLV值和握手帧负载的最大长度，长度字段为uint16
+const tlvMaxLen = 0xffff
+
+type tlvWriter []byte
+
+// put 写入一个TLV，v超过 tlvMaxLen 时返回 errors.ErrLengthOverflow，定长的putUint*不会出错
+func (w *tlvWriter) put(typ uint8, v []byte) error {
+	if len(v) > tlvMaxLen {
+		return errors.ErrLengthOverflow
+	}
+	*w = append(*w, typ, byte(len(v)), byte(len(v)>>8))
+	*w = append(*w, v...)
+	return nil
+}
+
+func (w *tlvWriter) putUint8(typ uint8, v uint8) {
+	w.put(typ, []byte{v})
+}
+
+func (w *tlvWriter) putUint32(typ uint8, v uint32) {
+	w.put(typ, binary.LittleEndian.AppendUint32(nil, v))
+}
+
+func (w *tlvWriter) putUint64(typ uint8, v uint64) {
+	w.put(typ, binary.LittleEndian.AppendUint64(nil, v))
+}
+
+// frame 封装为v2握手帧，负载超过 tlvMaxLen 时返回 errors.ErrLengthOverflow
+func (w tlvWriter) frame(version uint8) ([]byte, error) {
+	if len(w) > tlvMaxLen {
+		return nil, errors.ErrLengthOverflow
+	}
+	buf := make([]byte, 4+len(w))
+	buf[0] = HandshakeMagic
+	buf[1] = version
+	binary.LittleEndian.PutUint16(buf[2:4], uint16(len(w)))
+	copy(buf[4:], w)
+	return buf, nil
+}
+
+// readFrame 读取Magic之后的v2握手帧
+func readFrame(r io.Reader, timeout time.Duration) (version uint8, payload []byte, err error) {
+	deadline := time.Now().Add(timeout)
+	head := make([]byte, 3)
+	if err = ReadFull(r, timeout, head); err != nil {
+		return 0, nil, err
+	}
+	if head[0] < HandshakeV2 {
+		return 0, nil, errors.New("decode bad: handshake version invalid")
+	}
+	payload = make([]byte, binary.LittleEndian.Uint16(head[1:]))
+	if err = ReadFull(r, time.Until(deadline), payload); err != nil {
+		return 0, nil, err
+	}
+	return head[0], payload, nil
+}
+
+// readMagicFrame 读取完整的v2握手帧
+func readMagicFrame(r io.Reader, timeout time.Duration) (version uint8, payload []byte, err error) {
+	deadline := time.Now().Add(timeout)
+	magic := make([]byte, 1)
+	if err = ReadFull(r, timeout, magic); err != nil {
+		return 0, nil, err
+	}
+	if magic[0] != HandshakeMagic {
+		return 0, nil, errors.New("decode bad: handshake magic invalid")
+	}
+	return readFrame(r, time.Until(deadline))
+}
+
+// rangeTLV 依次遍历TLV，f返回false时停止
+func rangeTLV(b []byte, f func(typ uint8, v []byte) bool) error {
+	for len(b) > 0 {
+		if len(b) < 3 {
+			return errors.New("decode bad: tlv length invalid")
+		}
+		n := int(binary.LittleEndian.Uint16(b[1:3]))
+		if len(b) < 3+n {
+			return errors.New("decode bad: tlv length invalid")
+		}
+		if !f(b[0], b[3:3+n]) {
+			return nil
+		}
+		b = b[3+n:]
+	}
+	return nil
+}
+
+func getUint8(v []byte) uint8 {
+	if len(v) < 1 {
+		return 0
+	}
+	return v[0]
+}
+
+func getUint32(v []byte) uint32 {
+	if len(v) < 4 {
+		return 0
+	}
+	return binary.LittleEndian.Uint32(v)
+}
+
+func getUint64(v []byte) uint64 {
+	if len(v) < 8 {
+		return 0
+	}
+	return binary.LittleEndian.Uint64(v)
+}
@@ -33,10 +33,22 @@ func Hash(b []byte) []byte {
 	return h.Sum(nil)
 }
 
+// ReadFull 在timeout内读满buf，r实现了 SetReadDeadline 时（例如 net.Conn）使用读超时，返回前清除读超时
 func ReadFull(r io.Reader, timeout time.Duration, buf []byte) (err error) {
-	errC := make(chan error)
-	go func() {
+	if d, ok := r.(interface{ SetReadDeadline(t time.Time) error }); ok {
+		if err = d.SetReadDeadline(time.Now().Add(timeout)); err != nil {
+			return err
+		}
 		_, err = io.ReadFull(r, buf)
+		if e := d.SetReadDeadline(time.Time{}); err == nil {
+			err = e
+		}
+		return err
+	}
+	// 无法设置超时的Reader，超时返回后读取协程直至r返回才会退出
+	errC := make(chan error, 1)
+	go func() {
+		_, err := io.ReadFull(r, buf)
 		errC <- err
 	}()
 	select {
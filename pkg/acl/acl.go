@@ -0,0 +1,190 @@
+package acl
+
+import (
+	"encoding/json"
+	"github.com/Li-giegie/node/pkg/errors"
+	"os"
+	"strconv"
+	"sync"
+)
+
+// Policy 决定源节点能否向目的节点发送某类型的消息
+type Policy interface {
+	Allow(src, dst uint32, typ uint8) bool
+}
+
+type PolicyFunc func(src, dst uint32, typ uint8) bool
+
+func (f PolicyFunc) Allow(src, dst uint32, typ uint8) bool {
+	return f(src, dst, typ)
+}
+
+// AnyPolicy 可以直接判断源节点能否向目的节点发送任意类型消息的 Policy
+type AnyPolicy interface {
+	AllowAny(src, dst uint32) bool
+}
+
+// AllowAny 源节点能否向目的节点发送至少一种类型的消息，用于检查响应等不携带请求类型的消息，p未实现 AnyPolicy 时逐个类型检查
+func AllowAny(p Policy, src, dst uint32) bool {
+	if ap, ok := p.(AnyPolicy); ok {
+		return ap.AllowAny(src, dst)
+	}
+	for typ := 0; typ <= 0xff; typ++ {
+		if p.Allow(src, dst, uint8(typ)) {
+			return true
+		}
+	}
+	return false
+}
+
+// Rule 访问控制规则，Src、Dst、Types为空时匹配任意值
+type Rule struct {
+	// 源节点
+	Src []uint32
+	// 目的节点
+	Dst []uint32
+	// 消息类型
+	Types []uint8
+	// 匹配时是否允许
+	Allow bool
+}
+
+// Match 规则是否匹配该消息
+func (r *Rule) Match(src, dst uint32, typ uint8) bool {
+	return contains(r.Src, src) && contains(r.Dst, dst) && contains(r.Types, typ)
+}
+
+func contains[T uint32 | uint8](list []T, v T) bool {
+	if len(list) == 0 {
+		return true
+	}
+	for _, n := range list {
+		if n == v {
+			return true
+		}
+	}
+	return false
+}
+
+func NewRules(defaultAllow bool, rules ...Rule) *Rules {
+	return &Rules{defaultAllow: defaultAllow, rules: rules}
+}
+
+// Rules 按顺序匹配规则，第一条匹配的规则决定结果，均不匹配时使用默认策略，可以在运行期间并发的更新
+type Rules struct {
+	l            sync.RWMutex
+	defaultAllow bool
+	rules        []Rule
+}
+
+func (r *Rules) Allow(src, dst uint32, typ uint8) bool {
+	r.l.RLock()
+	defer r.l.RUnlock()
+	for i := range r.rules {
+		if r.rules[i].Match(src, dst, typ) {
+			return r.rules[i].Allow
+		}
+	}
+	return r.defaultAllow
+}
+
+// AllowAny 源节点能否向目的节点发送至少一种类型的消息
+func (r *Rules) AllowAny(src, dst uint32) bool {
+	r.l.RLock()
+	defer r.l.RUnlock()
+	// 已被之前的规则决定的类型
+	var decided [256]bool
+	for i := range r.rules {
+		rule := &r.rules[i]
+		if !contains(rule.Src, src) || !contains(rule.Dst, dst) {
+			continue
+		}
+		if len(rule.Types) == 0 {
+			// 匹配全部未决定的类型
+			return rule.Allow
+		}
+		for _, typ := range rule.Types {
+			if decided[typ] {
+				continue
+			}
+			if rule.Allow {
+				return true
+			}
+			decided[typ] = true
+		}
+	}
+	if !r.defaultAllow {
+		return false
+	}
+	for _, d := range decided {
+		if !d {
+			return true
+		}
+	}
+	return false
+}
+
+// Set 替换默认策略和全部规则
+func (r *Rules) Set(defaultAllow bool, rules ...Rule) {
+	r.l.Lock()
+	r.defaultAllow = defaultAllow
+	r.rules = rules
+	r.l.Unlock()
+}
+
+// Add 在末尾追加规则
+func (r *Rules) Add(rules ...Rule) {
+	r.l.Lock()
+	r.rules = append(r.rules, rules...)
+	r.l.Unlock()
+}
+
+// NewFileRules 从JSON文件加载规则，文件格式为 {"default_allow": false, "rules": [{"src": [1], "dst": [2], "types": [0], "allow": true}]}
+func NewFileRules(path string) (*FileRules, error) {
+	f := &FileRules{path: path, Rules: NewRules(false)}
+	if err := f.Reload(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// FileRules 文件中的规则，修改文件后调用 Reload 生效
+type FileRules struct {
+	path string
+	*Rules
+}
+
+type fileContent struct {
+	DefaultAllow bool `json:"default_allow"`
+	Rules        []struct {
+		Src   []uint32 `json:"src"`
+		Dst   []uint32 `json:"dst"`
+		Types []uint16 `json:"types"`
+		Allow bool     `json:"allow"`
+	} `json:"rules"`
+}
+
+// Reload 重新加载文件，加载失败时保留原有的规则
+func (f *FileRules) Reload() error {
+	b, err := os.ReadFile(f.path)
+	if err != nil {
+		return err
+	}
+	var content fileContent
+	if err = json.Unmarshal(b, &content); err != nil {
+		return err
+	}
+	rules := make([]Rule, 0, len(content.Rules))
+	for _, r := range content.Rules {
+		rule := Rule{Src: r.Src, Dst: r.Dst, Allow: r.Allow}
+		for _, typ := range r.Types {
+			if typ > 0xff {
+				return errors.New("acl: message type out of range: " + strconv.Itoa(int(typ)))
+			}
+			rule.Types = append(rule.Types, uint8(typ))
+		}
+		rules = append(rules, rule)
+	}
+	f.Set(content.DefaultAllow, rules...)
+	return nil
+}
@@ -0,0 +1,71 @@
+package acl
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRules(t *testing.T) {
+	r := NewRules(false,
+		Rule{Src: []uint32{10}, Dst: []uint32{11}, Types: []uint8{0}, Allow: true},
+		Rule{Src: []uint32{10, 11}, Dst: []uint32{10, 11}, Allow: true},
+	)
+	if !r.Allow(10, 11, 0) || !r.Allow(11, 10, 5) {
+		t.Fatal("tenant denied")
+	}
+	if r.Allow(10, 20, 0) || r.Allow(20, 10, 0) {
+		t.Fatal("cross tenant allowed")
+	}
+	r.Set(false, Rule{Dst: []uint32{11}, Allow: false})
+	r.Add(Rule{Allow: true})
+	if r.Allow(10, 11, 0) || !r.Allow(10, 20, 0) {
+		t.Fatal("first match not applied")
+	}
+}
+
+func TestFileRules(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "acl.json")
+	if err := os.WriteFile(path, []byte(`{"default_allow": true, "rules": [{"src": [1], "types": [7], "allow": false}]}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	r, err := NewFileRules(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Allow(1, 2, 7) || !r.Allow(1, 2, 0) {
+		t.Fatal("invalid rules")
+	}
+	if err = os.WriteFile(path, []byte(`{"rules": [{"types": [256]}]}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err = r.Reload(); err == nil {
+		t.Fatal("expected error")
+	}
+	if !r.Allow(1, 2, 0) {
+		t.Fatal("rules changed after failed reload")
+	}
+}
+
+func TestRulesAllowAny(t *testing.T) {
+	r := NewRules(false,
+		Rule{Src: []uint32{10}, Dst: []uint32{11}, Types: []uint8{1}, Allow: false},
+		Rule{Src: []uint32{10}, Dst: []uint32{11}, Types: []uint8{1, 2}, Allow: true},
+		Rule{Src: []uint32{20}, Allow: false},
+	)
+	if !r.AllowAny(10, 11) {
+		t.Fatal("type 2 allowed")
+	}
+	if r.AllowAny(11, 10) || r.AllowAny(20, 11) {
+		t.Fatal("no type allowed")
+	}
+	r.Set(true, Rule{Src: []uint32{20}, Allow: false}, Rule{Src: []uint32{30}, Types: []uint8{0}, Allow: false})
+	if r.AllowAny(20, 11) || !r.AllowAny(30, 11) || !r.AllowAny(11, 10) {
+		t.Fatal("invalid default")
+	}
+	// 未实现 AnyPolicy 的策略逐个类型检查
+	p := PolicyFunc(func(src, dst uint32, typ uint8) bool { return src == 10 && typ == 200 })
+	if !AllowAny(p, 10, 11) || AllowAny(p, 11, 10) {
+		t.Fatal("invalid policy func")
+	}
+}
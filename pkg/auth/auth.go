@@ -0,0 +1,77 @@
+package auth
+
+import (
+	"github.com/Li-giegie/node/pkg/conn"
+	"github.com/Li-giegie/node/pkg/errors"
+	"net"
+)
+
+// Info 建立连接时对端的认证信息
+type Info struct {
+	// 本端节点Id
+	LocalId uint32
+	// 对端声明的节点Id
+	RemoteId uint32
+	// 对端的连接类型，作为发起方调用 KeyAuthenticator.Keys 时为 conn.TypeUnknown
+	ConnType conn.Type
+	// 连接由本端发起（Client.Start、Server.Bridge）
+	Outbound bool
+	// 底层连接，TLS连接可以断言为 *tls.Conn 获取对端证书
+	Conn net.Conn
+	// 对端提供的凭据
+	Credentials Credentials
+}
+
+// Credentials 对端在握手中提供的凭据
+type Credentials struct {
+	// 对端发送的自定义凭据，例如令牌
+	Token []byte
+	// 对端已证明持有 KeyAuthenticator.Keys 返回的其中一个共享密钥
+	KeyVerified bool
+	// 使用了静态密钥摘要认证：作为接收方时KeyVerified表示摘要一致，作为发起方时对端无法证明持有密钥
+	Legacy bool
+	// 对端在握手中发送的元数据，例如客户端版本，未经认证，认证通过后通过 conn.Conn.PeerMetadata 获取，不写入会话属性
+	Metadata map[string]string
+}
+
+// Authenticator 校验对端并决定是否接受连接，握手的双方都会调用
+type Authenticator interface {
+	// Authenticate 返回错误时拒绝连接，错误信息作为拒绝原因发送给对端，返回的身份附加到连接上，可以为nil
+	Authenticate(info *Info) (*conn.Identity, error)
+}
+
+// KeyAuthenticator 使用共享密钥的 Authenticator，握手时先使用Keys返回的密钥进行挑战应答认证，结果位于 Credentials.KeyVerified
+type KeyAuthenticator interface {
+	Authenticator
+	// Keys 返回与对端共享的密钥，密钥轮换期间可以有多个，对端持有其中任意一个即可，为空时不进行密钥认证
+	Keys(info *Info) [][]byte
+}
+
+type AuthenticatorFunc func(info *Info) (*conn.Identity, error)
+
+func (f AuthenticatorFunc) Authenticate(info *Info) (*conn.Identity, error) {
+	return f(info)
+}
+
+// Key 返回使用单个共享密钥的 KeyAuthenticator，对端必须证明持有该密钥
+func Key(key []byte) KeyAuthenticator {
+	return keyAuthenticator(key)
+}
+
+type keyAuthenticator []byte
+
+func (k keyAuthenticator) Keys(*Info) [][]byte {
+	return [][]byte{k}
+}
+
+func (k keyAuthenticator) Authenticate(info *Info) (*conn.Identity, error) {
+	return nil, VerifyKey(info)
+}
+
+// VerifyKey 校验对端已通过密钥认证，作为发起方使用静态密钥摘要认证时无法校验对端，视为通过
+func VerifyKey(info *Info) error {
+	if info.Credentials.KeyVerified || info.Outbound && info.Credentials.Legacy {
+		return nil
+	}
+	return errors.ErrKeyRequired
+}
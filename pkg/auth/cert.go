@@ -0,0 +1,59 @@
+package auth
+
+import (
+	"crypto/x509"
+	"github.com/Li-giegie/node/pkg/conn"
+	"github.com/Li-giegie/node/pkg/errors"
+	"net"
+	"strconv"
+)
+
+// CertBinding 返回已验证的对端证书允许使用的节点Id，用于将mTLS证书与握手中声明的节点Id绑定
+type CertBinding func(cert *x509.Certificate) ([]uint32, error)
+
+// CertURIBinding 从证书SAN中指定scheme的URI读取节点Id，例如scheme为node时 node://1001 允许使用节点Id 1001
+func CertURIBinding(scheme string) CertBinding {
+	return func(cert *x509.Certificate) ([]uint32, error) {
+		var ids []uint32
+		for _, u := range cert.URIs {
+			if u.Scheme != scheme {
+				continue
+			}
+			id, err := strconv.ParseUint(u.Host, 10, 32)
+			if err != nil {
+				return nil, err
+			}
+			ids = append(ids, uint32(id))
+		}
+		return ids, nil
+	}
+}
+
+// CertCNBinding 将证书的CommonName解析为节点Id
+func CertCNBinding() CertBinding {
+	return func(cert *x509.Certificate) ([]uint32, error) {
+		id, err := strconv.ParseUint(cert.Subject.CommonName, 10, 32)
+		if err != nil {
+			return nil, err
+		}
+		return []uint32{uint32(id)}, nil
+	}
+}
+
+// VerifyCert 校验c的对端证书允许使用节点Id id，对端没有已验证的证书时返回 errors.ErrCertRequired
+func VerifyCert(binding CertBinding, c net.Conn, id uint32) error {
+	cert := conn.PeerCertificate(c)
+	if cert == nil {
+		return errors.ErrCertRequired
+	}
+	ids, err := binding(cert)
+	if err != nil {
+		return err
+	}
+	for _, n := range ids {
+		if n == id {
+			return nil
+		}
+	}
+	return errors.ErrCertMismatch
+}
@@ -0,0 +1,23 @@
+package auth
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/url"
+	"testing"
+)
+
+func TestCertBinding(t *testing.T) {
+	cert := &x509.Certificate{
+		Subject: pkix.Name{CommonName: "1002"},
+		URIs:    []*url.URL{{Scheme: "spiffe", Host: "example.org"}, {Scheme: "node", Host: "1001"}},
+	}
+	ids, err := CertURIBinding("node")(cert)
+	if err != nil || len(ids) != 1 || ids[0] != 1001 {
+		t.Fatal("invalid uri binding", ids, err)
+	}
+	ids, err = CertCNBinding()(cert)
+	if err != nil || len(ids) != 1 || ids[0] != 1002 {
+		t.Fatal("invalid cn binding", ids, err)
+	}
+}
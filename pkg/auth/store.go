@@ -0,0 +1,148 @@
+package auth
+
+import (
+	"encoding/json"
+	"github.com/Li-giegie/node/pkg/conn"
+	"os"
+	"strconv"
+	"sync"
+)
+
+// Store 按节点Id保存共享密钥
+type Store interface {
+	// Keys 返回节点当前有效的密钥，密钥轮换期间可以有多个，第一个用于作为发起方时的认证，未知节点返回空
+	Keys(id uint32) [][]byte
+}
+
+// Revoker 可以吊销节点凭据的 Store 或 Authenticator，见 Server.Revoke
+type Revoker interface {
+	Revoke(id uint32)
+}
+
+// KeyStore 返回从store按对端节点Id获取密钥的 KeyAuthenticator，对端必须证明持有其中一个密钥
+func KeyStore(store Store) KeyAuthenticator {
+	return &storeAuthenticator{store: store}
+}
+
+type storeAuthenticator struct {
+	store Store
+}
+
+func (a *storeAuthenticator) Keys(info *Info) [][]byte {
+	return a.store.Keys(info.RemoteId)
+}
+
+func (a *storeAuthenticator) Authenticate(info *Info) (*conn.Identity, error) {
+	return nil, VerifyKey(info)
+}
+
+// Revoke store实现 Revoker 时吊销节点的凭据
+func (a *storeAuthenticator) Revoke(id uint32) {
+	if r, ok := a.store.(Revoker); ok {
+		r.Revoke(id)
+	}
+}
+
+type StoreFunc func(id uint32) [][]byte
+
+func (f StoreFunc) Keys(id uint32) [][]byte {
+	return f(id)
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{keys: make(map[uint32][][]byte)}
+}
+
+// MemoryStore 内存中的 Store，可以并发使用
+type MemoryStore struct {
+	l    sync.RWMutex
+	keys map[uint32][][]byte
+}
+
+func (m *MemoryStore) Keys(id uint32) [][]byte {
+	m.l.RLock()
+	defer m.l.RUnlock()
+	return m.keys[id]
+}
+
+// Set 替换节点的全部密钥
+func (m *MemoryStore) Set(id uint32, keys ...[]byte) {
+	m.l.Lock()
+	if len(keys) == 0 {
+		delete(m.keys, id)
+	} else {
+		m.keys[id] = keys
+	}
+	m.l.Unlock()
+}
+
+// Add 为节点增加一个密钥，新密钥排在最前，轮换时先增加新密钥，所有节点更新后再 Remove 旧密钥
+func (m *MemoryStore) Add(id uint32, key []byte) {
+	m.l.Lock()
+	m.keys[id] = append([][]byte{key}, m.keys[id]...)
+	m.l.Unlock()
+}
+
+// Remove 删除节点的一个密钥
+func (m *MemoryStore) Remove(id uint32, key []byte) {
+	m.l.Lock()
+	defer m.l.Unlock()
+	old := m.keys[id]
+	keys := make([][]byte, 0, len(old))
+	for _, k := range old {
+		if string(k) != string(key) {
+			keys = append(keys, k)
+		}
+	}
+	if len(keys) == 0 {
+		delete(m.keys, id)
+	} else {
+		m.keys[id] = keys
+	}
+}
+
+// Revoke 删除节点的全部密钥
+func (m *MemoryStore) Revoke(id uint32) {
+	m.Set(id)
+}
+
+// NewFileStore 从JSON文件加载密钥，文件格式为 {"节点Id": ["密钥", ...]}
+func NewFileStore(path string) (*FileStore, error) {
+	f := &FileStore{path: path, MemoryStore: NewMemoryStore()}
+	if err := f.Reload(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// FileStore 文件中的 Store，修改文件后调用 Reload 生效，Revoke 只在内存中生效直至下次 Reload
+type FileStore struct {
+	path string
+	*MemoryStore
+}
+
+// Reload 重新加载文件，加载失败时保留原有的密钥
+func (f *FileStore) Reload() error {
+	b, err := os.ReadFile(f.path)
+	if err != nil {
+		return err
+	}
+	var content map[string][]string
+	if err = json.Unmarshal(b, &content); err != nil {
+		return err
+	}
+	keys := make(map[uint32][][]byte, len(content))
+	for idStr, list := range content {
+		id, err := strconv.ParseUint(idStr, 10, 32)
+		if err != nil {
+			return err
+		}
+		for _, k := range list {
+			keys[uint32(id)] = append(keys[uint32(id)], []byte(k))
+		}
+	}
+	f.l.Lock()
+	f.keys = keys
+	f.l.Unlock()
+	return nil
+}
@@ -0,0 +1,47 @@
+package auth
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMemoryStoreRotation(t *testing.T) {
+	s := NewMemoryStore()
+	s.Set(1, []byte("old"))
+	s.Add(1, []byte("new"))
+	if keys := s.Keys(1); len(keys) != 2 || string(keys[0]) != "new" {
+		t.Fatal("invalid keys", keys)
+	}
+	s.Remove(1, []byte("old"))
+	if keys := s.Keys(1); len(keys) != 1 || string(keys[0]) != "new" {
+		t.Fatal("invalid keys", keys)
+	}
+	KeyStore(s).(Revoker).Revoke(1)
+	if keys := s.Keys(1); len(keys) != 0 {
+		t.Fatal("not revoked", keys)
+	}
+}
+
+func TestFileStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys.json")
+	if err := os.WriteFile(path, []byte(`{"1": ["a", "b"], "2": ["c"]}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	s, err := NewFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keys := s.Keys(1); len(keys) != 2 || string(keys[1]) != "b" {
+		t.Fatal("invalid keys", keys)
+	}
+	if err = os.WriteFile(path, []byte(`{"2": ["d"]}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if len(s.Keys(1)) != 0 || string(s.Keys(2)[0]) != "d" {
+		t.Fatal("reload failed")
+	}
+}
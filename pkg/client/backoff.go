@@ -0,0 +1,45 @@
+package client
+
+import (
+	"math"
+	"math/rand"
+	"time"
+)
+
+// Backoff 断线重连的指数退避策略，第n次重连等待 Min*Multiplier^(n-1)，最大不超过Max，Jitter为随机抖动比例(0~1)
+type Backoff struct {
+	// 首次重连等待时长
+	Min time.Duration
+	// 最大等待时长
+	Max time.Duration
+	// 退避倍数，小于1时按1处理
+	Multiplier float64
+	// 随机抖动比例，取值0~1，等待时长会在 [d*(1-Jitter), d*(1+Jitter)] 之间随机
+	Jitter float64
+}
+
+// Next 返回第attempt次（从1开始）重连前的等待时长
+func (b *Backoff) Next(attempt int) time.Duration {
+	if attempt < 1 {
+		attempt = 1
+	}
+	mul := b.Multiplier
+	if mul < 1 {
+		mul = 1
+	}
+	d := float64(b.Min) * math.Pow(mul, float64(attempt-1))
+	if b.Max > 0 && d > float64(b.Max) {
+		d = float64(b.Max)
+	}
+	if b.Jitter > 0 {
+		jitter := b.Jitter
+		if jitter > 1 {
+			jitter = 1
+		}
+		d += d * jitter * (rand.Float64()*2 - 1)
+	}
+	if d < 0 {
+		return 0
+	}
+	return time.Duration(d)
+}
@@ -0,0 +1,21 @@
+package client
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBackoffNext(t *testing.T) {
+	b := Backoff{Min: time.Millisecond * 100, Max: time.Second, Multiplier: 2}
+	for attempt, want := range map[int]time.Duration{0: time.Millisecond * 100, 1: time.Millisecond * 100, 3: time.Millisecond * 400, 10: time.Second} {
+		if got := b.Next(attempt); got != want {
+			t.Errorf("Next(%d) = %v, want %v", attempt, got, want)
+		}
+	}
+	b.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		if got := b.Next(1); got < time.Millisecond*50 || got > time.Millisecond*150 {
+			t.Fatalf("Next(1) = %v out of jitter range", got)
+		}
+	}
+}
@@ -4,17 +4,26 @@ import (
 	"context"
 	"crypto/tls"
 	"github.com/Li-giegie/node/internal"
+	"github.com/Li-giegie/node/pkg/auth"
+	"github.com/Li-giegie/node/pkg/compress"
 	"github.com/Li-giegie/node/pkg/conn"
+	"github.com/Li-giegie/node/pkg/dispatch"
 	"github.com/Li-giegie/node/pkg/errors"
 	"github.com/Li-giegie/node/pkg/message"
+	"github.com/Li-giegie/node/pkg/middleware"
 	"github.com/Li-giegie/node/pkg/reply"
+	"github.com/Li-giegie/node/pkg/stream"
+	"log"
+	"math/rand"
 	"net"
+	"runtime/debug"
 	"sync"
 	"sync/atomic"
 	"time"
 )
 
 type Client struct {
+	// 节点Id，为0时请求服务端分配，分配的节点Id通过 NodeId 获取，断线重连时重新请求分配
 	Id uint32
 	// 远程节点ID
 	RemoteID uint32
@@ -22,12 +31,58 @@ type Client struct {
 	RemoteKey []byte
 	// 认证超时时长
 	AuthTimeout time.Duration
+	// 大于0启用，连接单个地址的超时时长
+	DialTimeout time.Duration
+	// ConnectAddrs 时打乱地址顺序
+	ShuffleAddresses bool
 	// 大于1时启用，并发请求或发送时，发出的消息不会被立即发出，而是会进入队列，直至队列缓冲区满，或者最后一个goroutine时才会将消息发出，如果消息要以最快的方式发出，那么请不要进入队列
 	WriterQueueSize int
 	// 读缓存区大小
 	ReaderBufSize int
 	// 大于64时启用，从队列读取后进入缓冲区，缓冲区大小
 	WriterBufSize int
+	// 断线重连，仅对通过Connect、ConnectAddrs建立的连接有效
+	EnableReconnect bool
+	// 断线重连退避策略
+	ReconnectBackoff Backoff
+	// 最大连续重连次数，小于等于0不限制
+	MaxReconnectAttempts int
+	// 消息分发模式，默认 dispatch.ModeInline 在读循环中同步调用OnMessage
+	DispatchMode dispatch.Mode
+	// DispatchMode为 dispatch.ModePool 时的工作协程数，小于等于0时为CPU核数
+	DispatchWorkers int
+	// DispatchMode为 dispatch.ModePool 时的任务队列长度
+	DispatchQueueSize int
+	// DispatchMode为 dispatch.ModePool 时，同一个源节点的消息按接收顺序处理
+	DispatchOrdered bool
+	// 用户回调（OnMessage、OnClose、OnReconnecting、OnReconnected）发生panic时的回调，c、m可能为nil，为nil时输出到标准日志
+	PanicHandler func(c *conn.Conn, m *message.Message, v any, stack []byte)
+	// 用户回调发生panic后是否关闭该连接，默认保持连接
+	CloseOnPanic bool
+	// 每个流的接收窗口大小，0时使用 stream.DefaultWindow
+	StreamWindow uint32
+	// 流的空闲超时，超过该时间未收到对端的任何帧时终止流，0时使用 stream.DefaultIdleTimeout，小于0时不检查
+	StreamIdleTimeout time.Duration
+	// 期望的消息完整性校验方式，与服务端协商后取较强的一种
+	Integrity conn.Integrity
+	// 握手版本，0为当前支持的最高版本，服务端为旧版本节点时设置为1
+	HandshakeVersion uint8
+	// 握手时向服务端声明支持的协议消息类型
+	Protocols []uint8
+	// 认证服务端，nil时使用 auth.Key(RemoteKey)
+	Authenticator auth.Authenticator
+	// 握手时发送给服务端的自定义凭据，例如令牌，不能超过 internal.HandshakeTokenMaxLen
+	Token []byte
+	// 握手时发送给服务端的元数据，例如客户端版本，服务端通过 conn.Conn.PeerMetadata 获取，编码后不能超过 internal.HandshakeMetadataMaxLen，仅v2握手支持
+	HandshakeMetadata map[string]string
+	// 使用静态密钥摘要认证代替挑战应答认证，密钥摘要可被截获重放，仅用于连接旧版本服务端，HandshakeVersion为1时总是使用
+	LegacyAuth bool
+	// 大于0时启用，接收消息最大长度，与服务端协商后取较小的一个，0时使用服务端的限制
+	MaxMsgLen uint32
+	// 发送消息使用的压缩算法，nil不压缩，服务端不支持该算法时不压缩
+	Compressor compress.Compressor
+	// Data长度不小于该值时压缩，0时使用 compress.DefaultThreshold
+	CompressThreshold int
 	internalField
 }
 type State uint32
@@ -35,99 +90,271 @@ type State uint32
 const (
 	StateClosed State = iota
 	StateRunning
+	StateReconnecting
 )
 
 type internalField struct {
 	recvChan map[uint32]chan *message.Message
 	recvLock sync.Mutex
+	msgIdSeq uint32
 	state    State
-	*conn.Conn
+	closeC   chan struct{}
+	// Connect、ConnectAddrs 的地址列表及当前使用的地址下标
+	endpoints   []endpoint
+	endpointIdx int
+	tlsConfig   *tls.Config
+	dispatcher  *dispatch.Dispatcher
+	inflight    internal.Inflight
+	streams     *stream.Manager
+	manager     Manager
+	// 当前连接，断线重连时被替换
+	current               atomic.Pointer[conn.Conn]
 	keepaliveInterval     time.Duration
 	keepaliveTimeout      time.Duration
 	keepaliveTimeoutClose time.Duration
+	// 节点Id由服务端分配
+	assignId bool
 	Handler
 }
 
 // Connect address 支持url格式例如 tcp://127.0.0.1:5555 = 127.0.0.1:5555，缺省协议默认tcp，config参数只能接受0个或者1个
 func (c *Client) Connect(address string, h Handler, config ...*tls.Config) (err error) {
-	network, addr := internal.ParseAddr(address)
-	var native net.Conn
+	return c.ConnectAddrs([]string{address}, h, config...)
+}
+
+// ConnectAddrs 按顺序依次连接地址列表，直至有一个地址连接并认证成功，连接断开时切换到下一个地址，地址格式同Connect，config参数只能接受0个或者1个，
+// 服务端繁忙时尝试下一个地址，全部繁忙时返回建议重试间隔最短的 *errors.ServerBusyError，否则返回最后一次失败的原因
+func (c *Client) ConnectAddrs(addresses []string, h Handler, config ...*tls.Config) (err error) {
+	if len(addresses) == 0 {
+		return errors.ErrAddressEmpty
+	}
+	c.tlsConfig = nil
 	if n := len(config); n > 0 {
 		if n != 1 {
 			panic("only one config option is allowed")
 		}
-		native, err = tls.Dial(network, addr, config[0])
-	} else {
-		native, err = net.Dial(network, addr)
+		c.tlsConfig = config[0]
 	}
-	if err != nil {
+	c.endpoints = make([]endpoint, len(addresses))
+	for i, address := range addresses {
+		c.endpoints[i].network, c.endpoints[i].address = internal.ParseAddr(address)
+	}
+	if c.ShuffleAddresses {
+		rand.Shuffle(len(c.endpoints), func(i, j int) {
+			c.endpoints[i], c.endpoints[j] = c.endpoints[j], c.endpoints[i]
+		})
+	}
+	c.init(h)
+	if err = c.dialEndpoints(0); err != nil {
 		return err
 	}
-	return c.Start(native, h)
+	c.run()
+	return nil
 }
 
+// Start 在已建立的连接上认证并开启服务，该方式建立的连接不支持断线重连
 func (c *Client) Start(native net.Conn, h Handler) (err error) {
+	c.endpoints = nil
+	c.init(h)
+	if err = c.auth(native); err != nil {
+		return err
+	}
+	c.run()
+	return nil
+}
+
+// init h为nil时使用实例上注册的处理器，实例上未注册的回调使用全局 Default
+func (c *Client) init(h Handler) {
+	if c.Id == 0 {
+		c.assignId = true
+	}
+	if h != nil {
+		c.Handler = h
+	} else {
+		c.manager.fallback = &Default
+		c.Handler = &c.manager
+	}
+	c.recvChan = make(map[uint32]chan *message.Message)
+	c.dispatcher = dispatch.New(c.DispatchMode, c.DispatchWorkers, c.DispatchQueueSize, c.DispatchOrdered)
+	c.streams = stream.NewManager(c.StreamWindow, c.StreamIdleTimeout)
+}
+
+func (c *Client) run() {
+	c.closeC = make(chan struct{})
+	atomic.StoreUint32((*uint32)(&c.state), uint32(StateRunning))
+	go c.Serve()
+}
+
+// dialEndpoints 从第start个地址开始依次尝试连接并认证，全部繁忙时返回建议重试间隔最短的 *errors.ServerBusyError，
+// 否则全部失败时返回最后一次失败的原因
+func (c *Client) dialEndpoints(start int) (err error) {
+	var native net.Conn
+	var busy *errors.ServerBusyError
+	allBusy := true
+	for i := 0; i < len(c.endpoints); i++ {
+		idx := (start + i) % len(c.endpoints)
+		if native, err = c.endpoints[idx].dial(c.DialTimeout, c.tlsConfig); err != nil {
+			allBusy = false
+			continue
+		}
+		if err = c.auth(native); err != nil {
+			if b, ok := err.(*errors.ServerBusyError); ok {
+				// 0为未指定，优先使用已指定的间隔
+				if busy == nil || b.RetryAfter > 0 && (busy.RetryAfter == 0 || b.RetryAfter < busy.RetryAfter) {
+					busy = b
+				}
+			} else {
+				allBusy = false
+			}
+			continue
+		}
+		c.endpointIdx = idx
+		return nil
+	}
+	if allBusy && busy != nil {
+		return busy
+	}
+	return err
+}
+
+// auth 在native上发起认证，成功后创建连接
+func (c *Client) auth(native net.Conn) (err error) {
 	defer func() {
 		if err != nil {
 			_ = native.Close()
 		}
 	}()
-	if h != nil {
-		c.Handler = h
-	} else {
-		c.Handler = &Default
-	}
-	err = internal.DefaultAuthService.Request(native, &internal.BaseAuthRequest{
-		ConnType: conn.TypeClient,
-		SrcId:    c.Id,
-		DstId:    c.RemoteID,
-		Key:      c.RemoteKey,
-	})
+	a := c.Authenticator
+	if a == nil {
+		a = auth.Key(c.RemoteKey)
+	}
+	id := c.Id
+	if c.assignId {
+		id = 0
+	}
+	info := &auth.Info{LocalId: id, RemoteId: c.RemoteID, Outbound: true, Conn: native}
+	var keys [][]byte
+	if ka, ok := a.(auth.KeyAuthenticator); ok {
+		keys = ka.Keys(info)
+	}
+	req := &internal.BaseAuthRequest{
+		ConnType:  conn.TypeClient,
+		SrcId:     id,
+		DstId:     c.RemoteID,
+		Token:     c.Token,
+		Integrity: c.Integrity,
+		Version:   internal.NegotiateVersion(c.HandshakeVersion),
+		Features:  conn.Features,
+		Protocols: c.Protocols,
+		MaxMsgLen: c.MaxMsgLen,
+		Codecs:    compress.Codecs(),
+		Metadata:  c.HandshakeMetadata,
+	}
+	if internal.MetadataLen(req.Metadata) > internal.HandshakeMetadataMaxLen || len(req.Token) > internal.HandshakeTokenMaxLen {
+		return errors.ErrLengthOverflow
+	}
+	// v1握手只支持静态密钥认证
+	if len(keys) > 0 {
+		req.Key = keys[0]
+	}
+	if req.Version < internal.HandshakeV2 || c.LegacyAuth && len(keys) > 0 {
+		req.Legacy = true
+	} else if len(keys) > 0 {
+		req.Nonce = internal.NewNonce()
+	}
+	resp, verified, err := internal.DefaultAuthService.Handshake(native, req, keys, c.AuthTimeout)
 	if err != nil {
 		return err
 	}
-	resp, err := internal.DefaultAuthService.ReadResponse(native, c.AuthTimeout)
-	if err != nil {
+	if err = resp.Err(); err != nil {
 		return err
 	}
-	if resp.Code != internal.BaseAuthResponseCodeSuccess {
-		return errors.New(resp.Code.String())
+	// 服务端未分配时（旧版本或未启用分配）0作为普通的节点Id
+	if c.assignId {
+		id = resp.AssignedId
+		info.LocalId = id
 	}
-	c.recvChan = make(map[uint32]chan *message.Message)
-	c.Conn = conn.NewConn(resp.ConnType, c.Id, c.RemoteID, native, c.recvChan, &c.recvLock, new(uint32), c.ReaderBufSize, c.WriterBufSize, c.WriterQueueSize, resp.MaxMsgLen)
+	info.ConnType = resp.ConnType
+	info.Credentials = auth.Credentials{Token: resp.Token, KeyVerified: verified, Legacy: req.Legacy}
+	var identity *conn.Identity
+	if c.protect(nil, nil, func() { identity, err = a.Authenticate(info) }) {
+		err = errors.New("internal error")
+	}
+	if err != nil {
+		return err
+	}
+	c.current.Store(conn.NewConn(resp.ConnType, id, c.RemoteID, native, c.recvChan, &c.recvLock, &c.msgIdSeq, c.ReaderBufSize, c.WriterBufSize, c.WriterQueueSize, internal.MinMsgLen(c.MaxMsgLen, resp.MaxMsgLen),
+		conn.WithStreamManager(c.streams),
+		conn.WithIntegrity(resp.Integrity),
+		conn.WithCompressor(c.Compressor, c.CompressThreshold),
+		conn.WithPeerInfo(conn.PeerInfo{Version: resp.Version, Features: resp.Features, Protocols: resp.Protocols, MaxMsgLen: resp.MaxMsgLen, Codecs: resp.Codecs}),
+		conn.WithIdentity(identity),
+	))
 	c.keepaliveInterval = resp.KeepaliveTimeout / 2
 	c.keepaliveTimeout = resp.KeepaliveTimeout / 2
 	c.keepaliveTimeoutClose = resp.KeepaliveTimeoutClose
-	go c.Serve()
 	return nil
 }
 
+// Serve 处理连接上的消息，启用断线重连或有多个地址时，连接断开后会自动重连，直至客户端被关闭或重连失败
 func (c *Client) Serve() (err error) {
-	c.state = StateRunning
+	for {
+		err = c.serve()
+		reconnect := (c.EnableReconnect || len(c.endpoints) > 1) && len(c.endpoints) > 0 && atomic.CompareAndSwapUint32((*uint32)(&c.state), uint32(StateRunning), uint32(StateReconnecting))
+		if !reconnect {
+			atomic.StoreUint32((*uint32)(&c.state), uint32(StateClosed))
+		}
+		c.protect(nil, nil, func() { c.Handler.OnClose(c.Conn(), err) })
+		if !reconnect {
+			c.dispatcher.Close()
+			return err
+		}
+		if err = c.reconnect(err); err != nil || c.State() != StateRunning {
+			atomic.StoreUint32((*uint32)(&c.state), uint32(StateClosed))
+			c.dispatcher.Close()
+			return err
+		}
+		if h, ok := c.Handler.(ReconnectHandler); ok {
+			c.protect(nil, nil, func() { h.OnReconnected(c.Conn()) })
+		}
+	}
+}
+
+func (c *Client) serve() (err error) {
+	// ctx在连接断开时取消，同时作为该连接上所有请求处理上下文的父上下文
 	ctx, cancel := context.WithCancel(context.Background())
-	go c.StartKeepalive(ctx)
+	cn := c.Conn()
+	keepaliveDone := make(chan struct{})
+	go func() {
+		defer close(keepaliveDone)
+		c.StartKeepalive(ctx)
+	}()
 	defer func() {
-		c.state = StateClosed
 		cancel()
-		_ = c.Conn.Close()
-		c.Handler.OnClose(c.Conn, err)
+		// 重连时会重新设置保活参数，等待该连接的保活协程退出
+		<-keepaliveDone
+		_ = cn.Close()
+		cn.ReleasePending()
+		c.streams.CloseSender(cn, errors.ErrConnClosed)
 	}()
 	for {
-		msg, err := c.Conn.ReadMessage()
+		msg, err := cn.ReadMessage()
 		if err != nil {
-			if c.state == StateRunning {
+			if c.State() == StateRunning {
 				return err
 			}
 			return nil
 		}
 		msg.Hop++
-		if msg.DestId != c.Id {
+		if msg.DestId != cn.LocalId() {
 			continue
 		}
 		switch msg.Type {
 		case message.MsgType_KeepaliveASK:
-			_ = c.Conn.SendType(message.MsgType_KeepaliveACK, nil)
+			_ = cn.SendType(message.MsgType_KeepaliveACK, nil)
 		case message.MsgType_KeepaliveACK:
+		case message.MsgType_GoAway:
+			cn.SetGoAway()
 		case message.MsgType_Response:
 			c.recvLock.Lock()
 			ch, ok := c.recvChan[msg.Id]
@@ -136,19 +363,165 @@ func (c *Client) Serve() (err error) {
 				delete(c.recvChan, msg.Id)
 			}
 			c.recvLock.Unlock()
+		case message.MsgType_Cancel:
+			c.inflight.Cancel(msg.SrcId, msg.Id)
+		case message.MsgType_Stream:
+			c.streams.Handle(cn, msg, c.acceptStream())
 		default:
-			c.Handler.OnMessage(reply.NewReply(c.Conn, msg.Id, msg.SrcId), msg)
+			mCtx, mCancel := c.inflight.Context(ctx, msg.SrcId, msg.Id, msg.Deadline)
+			r := reply.NewReplyWithContext(mCtx, cn, msg.Id, msg.SrcId)
+			c.dispatcher.Dispatch(msg.SrcId, func() {
+				defer mCancel()
+				c.protect(r, msg, func() { c.Handler.OnMessage(r, msg) })
+			})
+		}
+	}
+}
+
+// acceptStream 返回接受流的回调，Handler未实现 StreamHandler 或未设置流处理器时返回nil拒绝对端发起的流
+func (c *Client) acceptStream() func(s *stream.Stream) {
+	if c.Handler == Handler(&c.manager) && c.manager.streamHandler() == nil {
+		return nil
+	}
+	h, ok := c.Handler.(StreamHandler)
+	if !ok {
+		return nil
+	}
+	return func(s *stream.Stream) {
+		go func() {
+			defer s.Close()
+			c.protect(nil, nil, func() { h.OnStream(s) })
+		}()
+	}
+}
+
+// protect 执行用户回调f并捕获panic，r不为nil时回复 message.StateCode_InternalError，返回是否发生panic
+func (c *Client) protect(r *reply.Reply, m *message.Message, f func()) (panicked bool) {
+	defer func() {
+		v := recover()
+		if v == nil {
+			return
+		}
+		panicked = true
+		stack := debug.Stack()
+		var cn *conn.Conn
+		if r != nil {
+			cn = r.GetConn()
+			_ = r.Write(message.StateCode_InternalError, nil)
+		}
+		if c.PanicHandler != nil {
+			c.PanicHandler(cn, m, v, stack)
+		} else {
+			log.Printf("node: panic in handler: %v\n%s", v, stack)
+		}
+		if cn != nil && c.CloseOnPanic {
+			// 关闭前写出已回复的 message.StateCode_InternalError
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			_ = cn.Flush(ctx)
+			cancel()
+			_ = cn.Close()
+		}
+	}()
+	f()
+	return false
+}
+
+// reconnect 从下一个地址开始按退避策略重新连接并认证，客户端被关闭时返回nil，超过最大重连次数返回最后一次失败的原因，
+// 未启用断线重连时只将地址列表尝试一轮
+func (c *Client) reconnect(cause error) error {
+	maxAttempts := c.MaxReconnectAttempts
+	if !c.EnableReconnect {
+		maxAttempts = 1
+	}
+	h, _ := c.Handler.(ReconnectHandler)
+	for attempt := 1; maxAttempts <= 0 || attempt <= maxAttempts; attempt++ {
+		if h != nil {
+			c.protect(nil, nil, func() { h.OnReconnecting(attempt, cause) })
+		}
+		var delay time.Duration
+		// 有多个地址时首次立即切换到下一个地址
+		if attempt > 1 || len(c.endpoints) < 2 {
+			delay = c.ReconnectBackoff.Next(attempt)
+		}
+		// 服务端繁忙时至少等待其建议的重试间隔
+		if busy, ok := cause.(*errors.ServerBusyError); ok && busy.RetryAfter > delay {
+			delay = busy.RetryAfter
+		}
+		timer := time.NewTimer(delay)
+		select {
+		case <-c.closeC:
+			timer.Stop()
+			return nil
+		case <-timer.C:
+		}
+		if err := c.dialEndpoints(c.endpointIdx + 1); err != nil {
+			cause = err
+			continue
+		}
+		if !atomic.CompareAndSwapUint32((*uint32)(&c.state), uint32(StateReconnecting), uint32(StateRunning)) {
+			_ = c.Conn().Close()
 		}
+		return nil
 	}
+	return cause
 }
 
+// AddOnMessage 在当前实例上注册回调，Connect、Start的h为nil时生效
+func (c *Client) AddOnMessage(fn ...OnMessageFunc) {
+	c.manager.AddOnMessage(fn...)
+}
+
+func (c *Client) AddOnMessageWithType(typ uint8, fn OnMessageFunc) {
+	c.manager.AddOnMessageWithType(typ, fn)
+}
+
+// SetOnMessageWithType 替换当前实例上typ类型消息的全部处理器
+func (c *Client) SetOnMessageWithType(typ uint8, fn ...OnMessageFunc) {
+	c.manager.SetOnMessageWithType(typ, fn...)
+}
+
+// RemoveOnMessageWithType 移除当前实例上typ类型消息的全部处理器
+func (c *Client) RemoveOnMessageWithType(typ uint8) bool {
+	return c.manager.RemoveOnMessageWithType(typ)
+}
+
+func (c *Client) AddOnClose(fn ...OnCloseFunc) {
+	c.manager.AddOnClose(fn...)
+}
+
+// SetOnStream 设置当前实例上的流处理器
+func (c *Client) SetOnStream(fn OnStreamFunc) {
+	c.manager.SetOnStream(fn)
+}
+
+// Use 在当前实例上添加OnMessage中间件
+func (c *Client) Use(mw ...middleware.Middleware) {
+	c.manager.Use(mw...)
+}
+
+func (c *Client) AddOnReconnecting(fn ...OnReconnectingFunc) {
+	c.manager.AddOnReconnecting(fn...)
+}
+
+func (c *Client) AddOnReconnected(fn ...OnReconnectedFunc) {
+	c.manager.AddOnReconnected(fn...)
+}
+
+// NodeId 当前连接使用的节点Id，未连接时返回配置的 Id
 func (c *Client) NodeId() uint32 {
+	if cn := c.current.Load(); cn != nil {
+		return cn.LocalId()
+	}
 	return c.Id
 }
 
 func (c *Client) Close() error {
 	if atomic.CompareAndSwapUint32((*uint32)(&c.state), uint32(StateRunning), uint32(StateClosed)) {
-		return c.Conn.Close()
+		close(c.closeC)
+		return c.Conn().Close()
+	}
+	if atomic.CompareAndSwapUint32((*uint32)(&c.state), uint32(StateReconnecting), uint32(StateClosed)) {
+		close(c.closeC)
 	}
 	return nil
 }
@@ -157,26 +530,41 @@ func (c *Client) StartKeepalive(ctx context.Context) {
 	if c.keepaliveInterval < time.Millisecond*100 {
 		c.keepaliveInterval = time.Millisecond * 100
 	}
+	cn := c.Conn()
 	tick := time.NewTicker(c.keepaliveInterval)
 	defer tick.Stop()
-	go func() {
-		<-ctx.Done()
-		tick.Stop()
-	}()
 	var diff int64
 	var err error
-	for t := range tick.C {
-		diff = t.UnixNano() - int64(c.Conn.Activate())
-		if diff >= int64(c.keepaliveTimeoutClose) {
-			_ = c.Conn.Close()
-		} else if diff >= int64(c.keepaliveTimeout) {
-			if err = c.Conn.SendType(message.MsgType_KeepaliveASK, nil); err != nil {
-				_ = c.Conn.Close()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case t := <-tick.C:
+			diff = t.UnixNano() - int64(cn.Activate())
+			if diff >= int64(c.keepaliveTimeoutClose) {
+				_ = cn.Close()
+			} else if diff >= int64(c.keepaliveTimeout) {
+				if err = cn.SendType(message.MsgType_KeepaliveASK, nil); err != nil {
+					_ = cn.Close()
+				}
 			}
 		}
 	}
 }
 
 func (c *Client) State() State {
-	return c.state
+	return State(atomic.LoadUint32((*uint32)(&c.state)))
+}
+
+type endpoint struct {
+	network string
+	address string
+}
+
+func (e *endpoint) dial(timeout time.Duration, config *tls.Config) (net.Conn, error) {
+	d := &net.Dialer{Timeout: timeout}
+	if config != nil {
+		return tls.DialWithDialer(d, e.network, e.address, config)
+	}
+	return d.Dial(e.network, e.address)
 }
@@ -0,0 +1,159 @@
+package client
+
+import (
+	"context"
+	"github.com/Li-giegie/node/pkg/conn"
+	"github.com/Li-giegie/node/pkg/errors"
+	"github.com/Li-giegie/node/pkg/message"
+	"github.com/Li-giegie/node/pkg/stream"
+	"net"
+	"sync/atomic"
+)
+
+// Conn 当前连接，断线重连后为新的连接，连接建立前为nil，以下方法均作用于调用时的当前连接，
+// 连接建立前调用时返回 errors.ErrConnClosed 或零值
+func (c *Client) Conn() *conn.Conn {
+	return c.current.Load()
+}
+
+func (c *Client) Send(data []byte, md ...message.Metadata) error {
+	cn := c.Conn()
+	if cn == nil {
+		return errors.ErrConnClosed
+	}
+	return cn.Send(data, md...)
+}
+
+func (c *Client) SendMessage(m *message.Message) error {
+	cn := c.Conn()
+	if cn == nil {
+		return errors.ErrConnClosed
+	}
+	return cn.SendMessage(m)
+}
+
+func (c *Client) SendTo(dst uint32, data []byte, md ...message.Metadata) error {
+	cn := c.Conn()
+	if cn == nil {
+		return errors.ErrConnClosed
+	}
+	return cn.SendTo(dst, data, md...)
+}
+
+func (c *Client) SendType(typ uint8, data []byte, md ...message.Metadata) error {
+	cn := c.Conn()
+	if cn == nil {
+		return errors.ErrConnClosed
+	}
+	return cn.SendType(typ, data, md...)
+}
+
+func (c *Client) SendTypeTo(typ uint8, dst uint32, data []byte, md ...message.Metadata) error {
+	cn := c.Conn()
+	if cn == nil {
+		return errors.ErrConnClosed
+	}
+	return cn.SendTypeTo(typ, dst, data, md...)
+}
+
+func (c *Client) Request(ctx context.Context, data []byte, md ...message.Metadata) (int16, []byte, error) {
+	cn := c.Conn()
+	if cn == nil {
+		return 0, nil, errors.ErrConnClosed
+	}
+	return cn.Request(ctx, data, md...)
+}
+
+func (c *Client) RequestTo(ctx context.Context, dst uint32, data []byte, md ...message.Metadata) (int16, []byte, error) {
+	cn := c.Conn()
+	if cn == nil {
+		return 0, nil, errors.ErrConnClosed
+	}
+	return cn.RequestTo(ctx, dst, data, md...)
+}
+
+func (c *Client) RequestType(ctx context.Context, typ uint8, data []byte, md ...message.Metadata) (int16, []byte, error) {
+	cn := c.Conn()
+	if cn == nil {
+		return 0, nil, errors.ErrConnClosed
+	}
+	return cn.RequestType(ctx, typ, data, md...)
+}
+
+func (c *Client) RequestTypeTo(ctx context.Context, typ uint8, dst uint32, data []byte, md ...message.Metadata) (int16, []byte, error) {
+	cn := c.Conn()
+	if cn == nil {
+		return 0, nil, errors.ErrConnClosed
+	}
+	return cn.RequestTypeTo(ctx, typ, dst, data, md...)
+}
+
+func (c *Client) RequestMessage(ctx context.Context, msg *message.Message) (int16, []byte, error) {
+	cn := c.Conn()
+	if cn == nil {
+		return 0, nil, errors.ErrConnClosed
+	}
+	return cn.RequestMessage(ctx, msg)
+}
+
+func (c *Client) RequestResponse(ctx context.Context, msg *message.Message) (int16, *message.Message, error) {
+	cn := c.Conn()
+	if cn == nil {
+		return 0, nil, errors.ErrConnClosed
+	}
+	return cn.RequestResponse(ctx, msg)
+}
+
+func (c *Client) OpenStream(ctx context.Context) (*stream.Stream, error) {
+	cn := c.Conn()
+	if cn == nil {
+		return nil, errors.ErrConnClosed
+	}
+	return cn.OpenStream(ctx)
+}
+
+func (c *Client) OpenStreamTo(ctx context.Context, dst uint32) (*stream.Stream, error) {
+	cn := c.Conn()
+	if cn == nil {
+		return nil, errors.ErrConnClosed
+	}
+	return cn.OpenStreamTo(ctx, dst)
+}
+
+func (c *Client) CreateMessage(typ uint8, src uint32, dst uint32, data []byte) *message.Message {
+	return &message.Message{
+		Type:   typ,
+		Id:     c.CreateMessageId(),
+		SrcId:  src,
+		DestId: dst,
+		Data:   data,
+	}
+}
+
+func (c *Client) CreateMessageId() uint32 {
+	return atomic.AddUint32(&c.msgIdSeq, 1)
+}
+
+func (c *Client) LocalAddr() net.Addr {
+	cn := c.Conn()
+	if cn == nil {
+		return nil
+	}
+	return cn.LocalAddr()
+}
+
+func (c *Client) RemoteAddr() net.Addr {
+	cn := c.Conn()
+	if cn == nil {
+		return nil
+	}
+	return cn.RemoteAddr()
+}
+
+func (c *Client) ConnType() conn.Type {
+	cn := c.Conn()
+	if cn == nil {
+		return 0
+	}
+	return cn.ConnType()
+}
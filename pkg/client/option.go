@@ -1,11 +1,18 @@
 package client
 
-import "time"
+import (
+	"github.com/Li-giegie/node/pkg/auth"
+	"github.com/Li-giegie/node/pkg/compress"
+	"github.com/Li-giegie/node/pkg/conn"
+	"github.com/Li-giegie/node/pkg/dispatch"
+	"github.com/Li-giegie/node/pkg/message"
+	"time"
+)
 
 type Option func(*Config)
 
 type Config struct {
-	// 当前节点Id
+	// 当前节点Id，为0时请求服务端分配，见 server.IdAllocator
 	Id uint32
 	// 远程节点Id
 	RemoteId uint32
@@ -13,20 +20,75 @@ type Config struct {
 	RemoteKey []byte
 	// 认证超时时长
 	AuthTimeout time.Duration
+	// 大于0启用，连接单个地址的超时时长
+	DialTimeout time.Duration
+	// ConnectAddrs 时打乱地址顺序
+	ShuffleAddresses bool
 	// 大于1时启用，并发请求或发送时，发出的消息不会被立即发出，而是会进入队列，直至队列缓冲区满，或者最后一个goroutine时才会将消息发出，如果消息要以最快的方式发出，那么请不要进入队列
 	WriterQueueSize int
 	// 读缓存区大小
 	ReaderBufSize int
 	// 大于64时启用，从队列读取后进入缓冲区，缓冲区大小
 	WriterBufSize int
+	// 断线重连，仅对通过Connect、ConnectAddrs建立的连接有效
+	EnableReconnect bool
+	// 断线重连退避策略
+	ReconnectBackoff Backoff
+	// 最大连续重连次数，小于等于0不限制
+	MaxReconnectAttempts int
+	// 消息分发模式，默认 dispatch.ModeInline 在读循环中同步调用OnMessage
+	DispatchMode dispatch.Mode
+	// DispatchMode为 dispatch.ModePool 时的工作协程数，小于等于0时为CPU核数
+	DispatchWorkers int
+	// DispatchMode为 dispatch.ModePool 时的任务队列长度
+	DispatchQueueSize int
+	// DispatchMode为 dispatch.ModePool 时，同一个源节点的消息按接收顺序处理
+	DispatchOrdered bool
+	// 用户回调（OnMessage、OnClose、OnReconnecting、OnReconnected）发生panic时的回调，c、m可能为nil，为nil时输出到标准日志
+	PanicHandler func(c *conn.Conn, m *message.Message, v any, stack []byte)
+	// 用户回调发生panic后是否关闭该连接，默认保持连接
+	CloseOnPanic bool
+	// 每个流的接收窗口大小，0时使用 stream.DefaultWindow
+	StreamWindow uint32
+	// 流的空闲超时，超过该时间未收到对端的任何帧时终止流，0时使用 stream.DefaultIdleTimeout，小于0时不检查
+	StreamIdleTimeout time.Duration
+	// 期望的消息完整性校验方式，与对端协商后取较强的一种，v1握手时总是 conn.IntegrityLegacy，默认 conn.IntegrityCRC32C
+	Integrity conn.Integrity
+	// 握手版本，0为当前支持的最高版本，对端为旧版本节点时设置为1
+	HandshakeVersion uint8
+	// 握手时向对端声明支持的协议消息类型
+	Protocols []uint8
+	// 大于0时启用，接收消息最大长度，与服务端协商后取较小的一个，0时使用服务端的限制
+	MaxMsgLen uint32
+	// 发送消息使用的压缩算法，nil不压缩
+	Compressor compress.Compressor
+	// Data长度不小于该值时压缩，0时使用 compress.DefaultThreshold
+	CompressThreshold int
+	// 使用静态密钥摘要认证代替挑战应答认证，仅用于连接旧版本服务端
+	LegacyAuth bool
+	// 认证服务端，nil时使用 auth.Key(RemoteKey)
+	Authenticator auth.Authenticator
+	// 握手时发送给服务端的自定义凭据
+	Token []byte
+	// 握手时发送给服务端的元数据
+	HandshakeMetadata map[string]string
 }
 
 func DefaultConfig(opts ...Option) *Config {
 	c := &Config{
-		AuthTimeout:     time.Second * 6,
-		WriterQueueSize: 1024,
-		ReaderBufSize:   4096,
-		WriterBufSize:   4096,
+		AuthTimeout:       time.Second * 6,
+		DialTimeout:       time.Second * 6,
+		WriterQueueSize:   1024,
+		ReaderBufSize:     4096,
+		WriterBufSize:     4096,
+		DispatchQueueSize: 1024,
+		Integrity:         conn.IntegrityCRC32C,
+		ReconnectBackoff: Backoff{
+			Min:        time.Second,
+			Max:        time.Second * 30,
+			Multiplier: 2,
+			Jitter:     0.2,
+		},
 	}
 	for _, opt := range opts {
 		opt(c)
@@ -54,6 +116,16 @@ func WithAuthTimeout(timeout time.Duration) Option {
 		config.AuthTimeout = timeout
 	}
 }
+func WithDialTimeout(timeout time.Duration) Option {
+	return func(config *Config) {
+		config.DialTimeout = timeout
+	}
+}
+func WithShuffleAddresses(shuffle bool) Option {
+	return func(config *Config) {
+		config.ShuffleAddresses = shuffle
+	}
+}
 
 func WithWriterQueueSize(writerQueueSize int) Option {
 	return func(config *Config) {
@@ -70,3 +142,119 @@ func WithWriterBufSize(bufferSize int) Option {
 		config.WriterBufSize = bufferSize
 	}
 }
+func WithReconnect(enable bool) Option {
+	return func(config *Config) {
+		config.EnableReconnect = enable
+	}
+}
+func WithReconnectBackoff(min, max time.Duration, multiplier, jitter float64) Option {
+	return func(config *Config) {
+		config.ReconnectBackoff = Backoff{
+			Min:        min,
+			Max:        max,
+			Multiplier: multiplier,
+			Jitter:     jitter,
+		}
+	}
+}
+func WithMaxReconnectAttempts(n int) Option {
+	return func(config *Config) {
+		config.MaxReconnectAttempts = n
+	}
+}
+
+// WithDispatch 设置消息分发模式，workers、queueSize、ordered仅在 dispatch.ModePool 时有效
+func WithDispatch(mode dispatch.Mode, workers, queueSize int, ordered bool) Option {
+	return func(config *Config) {
+		config.DispatchMode = mode
+		config.DispatchWorkers = workers
+		config.DispatchQueueSize = queueSize
+		config.DispatchOrdered = ordered
+	}
+}
+
+// WithPanicHandler 设置用户回调发生panic时的回调，closeConn为true时关闭发生panic的连接
+func WithPanicHandler(f func(c *conn.Conn, m *message.Message, v any, stack []byte), closeConn bool) Option {
+	return func(config *Config) {
+		config.PanicHandler = f
+		config.CloseOnPanic = closeConn
+	}
+}
+
+// WithStreamWindow 设置每个流的接收窗口大小
+func WithStreamWindow(n uint32) Option {
+	return func(config *Config) {
+		config.StreamWindow = n
+	}
+}
+
+// WithStreamIdleTimeout 设置流的空闲超时，小于0时不检查
+func WithStreamIdleTimeout(d time.Duration) Option {
+	return func(config *Config) {
+		config.StreamIdleTimeout = d
+	}
+}
+
+// WithIntegrity 设置期望的消息完整性校验方式，conn.IntegrityLegacy 仅校验消息头
+func WithIntegrity(i conn.Integrity) Option {
+	return func(config *Config) {
+		config.Integrity = i
+	}
+}
+
+// WithHandshakeVersion 设置握手版本，与旧版本节点建立连接时设置为1
+func WithHandshakeVersion(v uint8) Option {
+	return func(config *Config) {
+		config.HandshakeVersion = v
+	}
+}
+
+// WithProtocols 设置握手时向对端声明支持的协议消息类型
+func WithProtocols(typ ...uint8) Option {
+	return func(config *Config) {
+		config.Protocols = typ
+	}
+}
+
+// WithMaxMsgLen 设置接收消息最大长度，与服务端协商后取较小的一个
+func WithMaxMsgLen(n uint32) Option {
+	return func(config *Config) {
+		config.MaxMsgLen = n
+	}
+}
+
+// WithCompression 设置发送消息使用的压缩算法，Data长度不小于threshold时压缩，threshold为0时使用 compress.DefaultThreshold
+func WithCompression(cp compress.Compressor, threshold int) Option {
+	return func(config *Config) {
+		config.Compressor = cp
+		config.CompressThreshold = threshold
+	}
+}
+
+// WithLegacyAuth 设置是否使用静态密钥摘要认证，静态密钥摘要可被截获重放
+func WithLegacyAuth(enable bool) Option {
+	return func(config *Config) {
+		config.LegacyAuth = enable
+	}
+}
+
+// WithAuthenticator 设置认证服务端的 auth.Authenticator
+func WithAuthenticator(a auth.Authenticator) Option {
+	return func(config *Config) {
+		config.Authenticator = a
+	}
+}
+
+// WithToken 设置握手时发送给服务端的自定义凭据
+func WithToken(token []byte) Option {
+	return func(config *Config) {
+		config.Token = token
+	}
+}
+
+// WithHandshakeMetadata 设置握手时发送给服务端的元数据，例如客户端版本，服务端通过 conn.Conn.PeerMetadata 获取
+func WithHandshakeMetadata(md map[string]string) Option {
+	return func(config *Config) {
+		config.HandshakeMetadata = md
+	}
+}
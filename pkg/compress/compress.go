@@ -0,0 +1,147 @@
+package compress
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"github.com/Li-giegie/node/pkg/errors"
+	"io"
+	"sort"
+	"sync"
+)
+
+// 内置的压缩算法编号，自定义的 Compressor 建议使用128及以上的编号
+const (
+	CodecDeflate uint8 = iota + 1
+	CodecGzip
+)
+
+// DefaultThreshold 默认的压缩阈值，Data长度小于该值的消息不压缩
+const DefaultThreshold = 1024
+
+// Compressor 消息Data的压缩算法，实现必须可以被并发调用
+type Compressor interface {
+	// Codec 算法编号，位于消息扩展字段中，两端相同编号的算法必须一致，不能为0
+	Codec() uint8
+	Compress(src []byte) ([]byte, error)
+	// Decompress 解压src，limit大于0时解压后的长度超过limit返回 errors.ErrLengthOverflow
+	Decompress(src []byte, limit uint32) ([]byte, error)
+}
+
+var (
+	Deflate Compressor = &flateCompressor{codec: CodecDeflate}
+	Gzip    Compressor = &flateCompressor{codec: CodecGzip, gzip: true}
+)
+
+var (
+	l        sync.RWMutex
+	registry = map[uint8]Compressor{CodecDeflate: Deflate, CodecGzip: Gzip}
+)
+
+// Register 注册压缩算法，编号相同时覆盖，已注册的算法在握手时声明给对端，收到对应编号的消息时用于解压
+func Register(c Compressor) {
+	if c.Codec() == 0 {
+		panic("compress: codec 0 is reserved")
+	}
+	l.Lock()
+	registry[c.Codec()] = c
+	l.Unlock()
+}
+
+func Get(codec uint8) (Compressor, bool) {
+	l.RLock()
+	c, ok := registry[codec]
+	l.RUnlock()
+	return c, ok
+}
+
+// Codecs 返回已注册的全部算法编号
+func Codecs() []uint8 {
+	l.RLock()
+	codecs := make([]uint8, 0, len(registry))
+	for codec := range registry {
+		codecs = append(codecs, codec)
+	}
+	l.RUnlock()
+	sort.Slice(codecs, func(i, j int) bool { return codecs[i] < codecs[j] })
+	return codecs
+}
+
+// Decompress 使用codec对应的已注册算法解压，未注册时返回 errors.ErrCodecUnsupported
+func Decompress(codec uint8, src []byte, limit uint32) ([]byte, error) {
+	c, ok := Get(codec)
+	if !ok {
+		return nil, errors.ErrCodecUnsupported
+	}
+	return c.Decompress(src, limit)
+}
+
+// flateCompressor DEFLATE及gzip，复用压缩器以减少内存分配
+type flateCompressor struct {
+	codec uint8
+	gzip  bool
+	pool  sync.Pool
+}
+
+func (f *flateCompressor) Codec() uint8 {
+	return f.codec
+}
+
+func (f *flateCompressor) Compress(src []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.Grow(len(src) / 2)
+	var w io.WriteCloser
+	if f.gzip {
+		gw, _ := f.pool.Get().(*gzip.Writer)
+		if gw == nil {
+			gw = gzip.NewWriter(&buf)
+		} else {
+			gw.Reset(&buf)
+		}
+		defer f.pool.Put(gw)
+		w = gw
+	} else {
+		fw, _ := f.pool.Get().(*flate.Writer)
+		if fw == nil {
+			fw, _ = flate.NewWriter(&buf, flate.DefaultCompression)
+		} else {
+			fw.Reset(&buf)
+		}
+		defer f.pool.Put(fw)
+		w = fw
+	}
+	if _, err := w.Write(src); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (f *flateCompressor) Decompress(src []byte, limit uint32) ([]byte, error) {
+	var r io.ReadCloser
+	if f.gzip {
+		gr, err := gzip.NewReader(bytes.NewReader(src))
+		if err != nil {
+			return nil, err
+		}
+		r = gr
+	} else {
+		r = flate.NewReader(bytes.NewReader(src))
+	}
+	defer r.Close()
+	var lr io.Reader = r
+	if limit > 0 {
+		// 多读1字节用于判断是否超出限制
+		lr = io.LimitReader(r, int64(limit)+1)
+	}
+	out, err := io.ReadAll(lr)
+	if err != nil {
+		return nil, err
+	}
+	if limit > 0 && len(out) > int(limit) {
+		return nil, errors.ErrLengthOverflow
+	}
+	return out, nil
+}
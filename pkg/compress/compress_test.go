@@ -0,0 +1,26 @@
+package compress
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestCompressor(t *testing.T) {
+	data := bytes.Repeat([]byte(`{"id":1,"name":"node"},`), 1000)
+	for _, c := range []Compressor{Deflate, Gzip} {
+		out, err := c.Compress(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(out) >= len(data)/4 {
+			t.Fatal("codec", c.Codec(), "compressed", len(out))
+		}
+		got, err := Decompress(c.Codec(), out, uint32(len(data)))
+		if err != nil || !bytes.Equal(got, data) {
+			t.Fatal("codec", c.Codec(), err)
+		}
+		if _, err = c.Decompress(out, uint32(len(data)-1)); err == nil {
+			t.Fatal("codec", c.Codec(), "expected length overflow")
+		}
+	}
+}
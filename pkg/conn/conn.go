@@ -3,10 +3,15 @@ package conn
 import (
 	"bufio"
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/binary"
 	"github.com/Li-giegie/node/internal/bufwriter"
+	"github.com/Li-giegie/node/pkg/compress"
 	"github.com/Li-giegie/node/pkg/errors"
 	"github.com/Li-giegie/node/pkg/message"
+	"github.com/Li-giegie/node/pkg/stream"
+	"hash/crc32"
 	"io"
 	"net"
 	"sync"
@@ -14,7 +19,7 @@ import (
 	"time"
 )
 
-func NewConn(typ Type, localId, remoteId uint32, conn net.Conn, revChan map[uint32]chan *message.Message, revLock *sync.Mutex, msgIdSeq *uint32, rBufSize, wBufSize, writerQueueSize int, maxMsgLen uint32) *Conn {
+func NewConn(typ Type, localId, remoteId uint32, conn net.Conn, revChan map[uint32]chan *message.Message, revLock *sync.Mutex, msgIdSeq *uint32, rBufSize, wBufSize, writerQueueSize int, maxMsgLen uint32, opts ...Option) *Conn {
 	var c Conn
 	c.typ = typ
 	c.localId = localId
@@ -24,8 +29,8 @@ func NewConn(typ Type, localId, remoteId uint32, conn net.Conn, revChan map[uint
 	c.unixNano = time.Now().UnixNano()
 	c.revChan = revChan
 	c.revLock = revLock
+	c.pending = make(map[uint32]struct{})
 	c.conn = conn
-	c.headerBuf = make([]byte, message.MsgHeaderLen)
 	if rBufSize > 16 {
 		c.r = bufio.NewReaderSize(conn, rBufSize)
 	} else {
@@ -38,6 +43,21 @@ func NewConn(typ Type, localId, remoteId uint32, conn net.Conn, revChan map[uint
 	} else {
 		c.w = conn
 	}
+	for _, opt := range opts {
+		opt(&c)
+	}
+	c.headerFlags = c.peer.Supports(FeatureHeaderFlags)
+	if c.headerFlags {
+		c.headerBuf = make([]byte, message.MsgHeaderLenFlags)
+	} else {
+		c.headerBuf = make([]byte, message.MsgHeaderLen)
+	}
+	if c.compressor != nil && (!c.headerFlags || !c.peer.SupportsCodec(c.compressor.Codec())) {
+		c.compressor = nil
+	}
+	if c.compressThreshold <= 0 {
+		c.compressThreshold = compress.DefaultThreshold
+	}
 	return &c
 }
 
@@ -49,190 +69,444 @@ type Conn struct {
 	msgIdSeq  *uint32
 	unixNano  int64
 	headerBuf []byte
-	revChan   map[uint32]chan *message.Message
-	revLock   *sync.Mutex
-	conn      net.Conn
-	w         io.WriteCloser
-	r         io.Reader
+	// 消息头是否携带Flags字段，对端声明 FeatureHeaderFlags 时为true
+	headerFlags bool
+	revChan     map[uint32]chan *message.Message
+	revLock     *sync.Mutex
+	pending     map[uint32]struct{} // 当前连接上等待响应的请求Id，与revChan共用revLock
+	closed      bool
+	goAway      uint32
+	integrity   Integrity
+	peer        PeerInfo
+	streams     *stream.Manager
+	conn        net.Conn
+	w           io.WriteCloser
+	r           io.Reader
+	// 发送时使用的压缩算法，nil不压缩
+	compressor        compress.Compressor
+	compressThreshold int
+	identity          *Identity
+	// 对端在握手中发送的元数据
+	peerMetadata map[string]string
+	// CloseWithError 指定的关闭原因
+	closeErr atomic.Value
+	// 会话属性
+	attrs sync.Map
 }
 
 func (c *Conn) ReadMessage() (*message.Message, error) {
-	_, err := io.ReadAtLeast(c.r, c.headerBuf, message.MsgHeaderLen)
-	if err != nil {
-		return nil, err
+	for {
+		m, code, err := c.readMessage()
+		if code == 0 {
+			if err != nil {
+				if reason, ok := c.closeErr.Load().(closeReason); ok {
+					err = reason.err
+				}
+			}
+			return m, err
+		}
+		// 消息头完整但消息体校验失败或无法解压，连接仍然可用：响应交给等待的请求方，流帧无法恢复关闭连接，其他消息回复状态码后丢弃
+		switch m.Type {
+		case message.MsgType_Response:
+			m.Data = codeData(code)
+			m.Metadata = nil
+			m.Deadline = 0
+			return m, nil
+		case message.MsgType_Stream:
+			if code == message.StateCode_CheckSumInvalid {
+				return nil, errors.ErrChecksumInvalid
+			}
+			return nil, errors.ErrCodecUnsupported
+		default:
+			c.replyCode(m, code)
+		}
+	}
+}
+
+// readMessage 读取一条消息，消息体校验失败或无法解压时返回已解析消息头的消息及应回复的状态码
+func (c *Conn) readMessage() (m *message.Message, code int16, err error) {
+	headerLen := len(c.headerBuf)
+	if _, err = io.ReadAtLeast(c.r, c.headerBuf, headerLen); err != nil {
+		return nil, 0, err
 	}
 	c.unixNano = time.Now().UnixNano()
 	var checksum uint16
-	for i := 0; i < message.MsgHeaderLen-2; i++ {
+	for i := 0; i < headerLen-2; i++ {
 		checksum += uint16(c.headerBuf[i])
 	}
-	var m message.Message
+	m = new(message.Message)
 	m.Type = c.headerBuf[0]
 	m.Hop = c.headerBuf[1]
-	m.Id = binary.LittleEndian.Uint32(c.headerBuf[2:6])
-	m.SrcId = binary.LittleEndian.Uint32(c.headerBuf[6:10])
-	m.DestId = binary.LittleEndian.Uint32(c.headerBuf[10:14])
-	if checksum != binary.LittleEndian.Uint16(c.headerBuf[message.MsgHeaderLen-2:]) {
-		m.SrcId, m.DestId = c.localId, m.SrcId
-		m.Type = message.MsgType_Response
-		m.Data = []byte{byte(message.StateCode_CheckSumInvalid), byte(message.StateCode_CheckSumInvalid >> 8)}
-		_ = c.SendMessage(&m)
-		return nil, errors.ErrChecksumInvalid
-	}
-	dataLen := binary.LittleEndian.Uint32(c.headerBuf[14:18])
+	var flags uint8
+	h := c.headerBuf[2:]
+	if c.headerFlags {
+		flags = h[0]
+		h = h[1:]
+	}
+	m.Id = binary.LittleEndian.Uint32(h[0:4])
+	m.SrcId = binary.LittleEndian.Uint32(h[4:8])
+	m.DestId = binary.LittleEndian.Uint32(h[8:12])
+	if checksum != binary.LittleEndian.Uint16(h[16:18]) {
+		c.replyCode(m, message.StateCode_CheckSumInvalid)
+		return nil, 0, errors.ErrChecksumInvalid
+	}
+	dataLen := binary.LittleEndian.Uint32(h[12:16])
 	if dataLen > c.maxMsgLen && c.maxMsgLen > 0 {
-		m.SrcId, m.DestId = c.localId, m.SrcId
-		m.Type = message.MsgType_Response
-		m.Data = []byte{byte(message.StateCode_LengthOverflow), byte(message.StateCode_LengthOverflow >> 8)}
-		_ = c.SendMessage(&m)
-		return nil, errors.ErrLengthOverflow
+		c.replyCode(m, message.StateCode_LengthOverflow)
+		return nil, 0, errors.ErrLengthOverflow
+	}
+	bodyLen := int(dataLen)
+	if c.integrity == IntegrityCRC32C {
+		bodyLen += 4
+	}
+	if bodyLen > 0 {
+		m.Data = make([]byte, bodyLen)
+		if _, err = io.ReadAtLeast(c.r, m.Data, bodyLen); err != nil {
+			return nil, 0, err
+		}
+	}
+	if c.integrity == IntegrityCRC32C {
+		sum := crc32.Update(crc32.Checksum(c.headerBuf, crc32cTable), crc32cTable, m.Data[:dataLen])
+		if sum != binary.LittleEndian.Uint32(m.Data[dataLen:]) {
+			return m, message.StateCode_CheckSumInvalid, nil
+		}
+		m.Data = m.Data[:dataLen:dataLen]
+		if dataLen == 0 {
+			m.Data = nil
+		}
+	}
+	if flags != 0 {
+		if err = decodeExtension(m, flags); err != nil {
+			return nil, 0, err
+		}
 	}
-	if dataLen > 0 {
-		m.Data = make([]byte, dataLen)
-		_, err = io.ReadAtLeast(c.r, m.Data, int(dataLen))
+	// 只解压发给本节点的消息，转发的消息保持压缩
+	if m.Compression != 0 && m.DestId == c.localId {
+		if m.Data, err = compress.Decompress(m.Compression, m.Data, c.maxMsgLen); err != nil {
+			return m, message.StateCode_CodecUnsupported, nil
+		}
+		m.Compression = 0
 	}
-	return &m, err
+	return m, 0, nil
+}
+
+// replyCode 向消息的发送方回复状态码
+func (c *Conn) replyCode(m *message.Message, code int16) {
+	_ = c.SendMessage(&message.Message{
+		Type:   message.MsgType_Response,
+		Id:     m.Id,
+		SrcId:  c.localId,
+		DestId: m.SrcId,
+		Data:   codeData(code),
+	})
+}
+
+func codeData(code int16) []byte {
+	return []byte{byte(code), byte(code >> 8)}
+}
+
+// typeFeature 内部消息类型依赖的功能，对端未声明时 SendMessage 返回 errors.ErrFeatureUnsupported
+var typeFeature = map[uint8]Feature{
+	message.MsgType_GoAway: FeatureGoAway,
+	message.MsgType_Cancel: FeatureCancel,
+	message.MsgType_Stream: FeatureStream,
 }
 
 func (c *Conn) SendMessage(m *message.Message) error {
 	if m.DestId == c.localId {
 		return errors.ErrWriteMsgYourself
 	}
-	msgLen := message.MsgHeaderLen + len(m.Data)
+	if f, ok := typeFeature[m.Type]; ok && !c.peer.Supports(f) {
+		return errors.ErrFeatureUnsupported
+	}
+	// 对端不支持的扩展不发送
+	if m.Deadline != 0 && !c.peer.Supports(FeatureDeadline) || len(m.Metadata) > 0 && !c.peer.Supports(FeatureMetadata) {
+		cp := *m
+		if !c.peer.Supports(FeatureDeadline) {
+			cp.Deadline = 0
+		}
+		if !c.peer.Supports(FeatureMetadata) {
+			cp.Metadata = nil
+		}
+		m = &cp
+	}
+	payload, codec, err := c.encodeData(m)
+	if err != nil {
+		return err
+	}
+	var flags uint8
+	var extLen int
+	if c.headerFlags {
+		if flags, extLen, err = extensionLen(m, codec); err != nil {
+			return err
+		}
+	}
+	headerLen := len(c.headerBuf)
+	msgLen := headerLen + extLen + len(payload)
 	if msgLen > int(c.maxMsgLen) && c.maxMsgLen > 0 {
 		return errors.ErrLengthOverflow
 	}
-	data := make([]byte, msgLen)
+	size := msgLen
+	if c.integrity == IntegrityCRC32C {
+		size += 4
+	}
+	data := make([]byte, size)
 	data[0] = m.Type
 	data[1] = m.Hop
-	binary.LittleEndian.PutUint32(data[2:6], m.Id)
-	binary.LittleEndian.PutUint32(data[6:10], m.SrcId)
-	binary.LittleEndian.PutUint32(data[10:14], m.DestId)
-	binary.LittleEndian.PutUint32(data[14:18], uint32(len(m.Data)))
+	h := data[2:]
+	if c.headerFlags {
+		h[0] = flags
+		h = h[1:]
+	}
+	binary.LittleEndian.PutUint32(h[0:4], m.Id)
+	binary.LittleEndian.PutUint32(h[4:8], m.SrcId)
+	binary.LittleEndian.PutUint32(h[8:12], m.DestId)
+	binary.LittleEndian.PutUint32(h[12:16], uint32(extLen+len(payload)))
 	var checksum uint16
-	for i := 0; i < 18; i++ {
+	for i := 0; i < headerLen-2; i++ {
 		checksum += uint16(data[i])
 	}
-	binary.LittleEndian.PutUint16(data[18:], checksum)
-	copy(data[20:], m.Data)
-	_, err := c.w.Write(data)
+	binary.LittleEndian.PutUint16(h[16:18], checksum)
+	n := headerLen + encodeExtension(m, flags, codec, data[headerLen:])
+	copy(data[n:], payload)
+	if c.integrity == IntegrityCRC32C {
+		binary.LittleEndian.PutUint32(data[msgLen:], crc32.Checksum(data[:msgLen], crc32cTable))
+	}
+	_, err = c.w.Write(data)
 	return err
 }
 
-func (c *Conn) Send(data []byte) error {
+// encodeData 返回发送时的Data及其压缩算法编号，已压缩的转发消息在对端支持该算法时原样发送
+func (c *Conn) encodeData(m *message.Message) ([]byte, uint8, error) {
+	data := m.Data
+	if m.Compression != 0 {
+		if c.headerFlags && c.peer.SupportsCodec(m.Compression) {
+			return data, m.Compression, nil
+		}
+		var err error
+		if data, err = compress.Decompress(m.Compression, data, c.maxMsgLen); err != nil {
+			return nil, 0, err
+		}
+	}
+	if c.compressor == nil || len(data) < c.compressThreshold {
+		return data, 0, nil
+	}
+	out, err := c.compressor.Compress(data)
+	// 压缩失败或没有变小时按原数据发送
+	if err != nil || len(out) >= len(data) {
+		return data, 0, nil
+	}
+	return out, c.compressor.Codec(), nil
+}
+
+func (c *Conn) Send(data []byte, md ...message.Metadata) error {
 	return c.SendMessage(&message.Message{
-		Type:   message.MsgType_Default,
-		Hop:    0,
-		Id:     atomic.AddUint32(c.msgIdSeq, 1),
-		SrcId:  c.localId,
-		DestId: c.remoteId,
-		Data:   data,
+		Type:     message.MsgType_Default,
+		Hop:      0,
+		Id:       atomic.AddUint32(c.msgIdSeq, 1),
+		SrcId:    c.localId,
+		DestId:   c.remoteId,
+		Data:     data,
+		Metadata: message.MergeMetadata(md...),
+		NoReply:  true,
 	})
 }
 
-func (c *Conn) SendTo(dst uint32, data []byte) error {
+func (c *Conn) SendTo(dst uint32, data []byte, md ...message.Metadata) error {
 	return c.SendMessage(&message.Message{
-		Type:   message.MsgType_Default,
-		Hop:    0,
-		Id:     atomic.AddUint32(c.msgIdSeq, 1),
-		SrcId:  c.localId,
-		DestId: dst,
-		Data:   data,
+		Type:     message.MsgType_Default,
+		Hop:      0,
+		Id:       atomic.AddUint32(c.msgIdSeq, 1),
+		SrcId:    c.localId,
+		DestId:   dst,
+		Data:     data,
+		Metadata: message.MergeMetadata(md...),
+		NoReply:  true,
 	})
 }
 
-func (c *Conn) SendType(typ uint8, data []byte) error {
+func (c *Conn) SendType(typ uint8, data []byte, md ...message.Metadata) error {
 	return c.SendMessage(&message.Message{
-		Type:   typ,
-		Hop:    0,
-		Id:     atomic.AddUint32(c.msgIdSeq, 1),
-		SrcId:  c.localId,
-		DestId: c.remoteId,
-		Data:   data,
+		Type:     typ,
+		Hop:      0,
+		Id:       atomic.AddUint32(c.msgIdSeq, 1),
+		SrcId:    c.localId,
+		DestId:   c.remoteId,
+		Data:     data,
+		Metadata: message.MergeMetadata(md...),
+		NoReply:  true,
 	})
 }
 
-func (c *Conn) SendTypeTo(typ uint8, dst uint32, data []byte) error {
+func (c *Conn) SendTypeTo(typ uint8, dst uint32, data []byte, md ...message.Metadata) error {
 	return c.SendMessage(&message.Message{
-		Type:   typ,
-		Hop:    0,
-		Id:     atomic.AddUint32(c.msgIdSeq, 1),
-		SrcId:  c.localId,
-		DestId: dst,
-		Data:   data,
+		Type:     typ,
+		Hop:      0,
+		Id:       atomic.AddUint32(c.msgIdSeq, 1),
+		SrcId:    c.localId,
+		DestId:   dst,
+		Data:     data,
+		Metadata: message.MergeMetadata(md...),
+		NoReply:  true,
 	})
 }
 
-func (c *Conn) Request(ctx context.Context, data []byte) (int16, []byte, error) {
+func (c *Conn) Request(ctx context.Context, data []byte, md ...message.Metadata) (int16, []byte, error) {
 	return c.RequestMessage(ctx, &message.Message{
-		Type:   message.MsgType_Default,
-		Id:     atomic.AddUint32(c.msgIdSeq, 1),
-		SrcId:  c.localId,
-		DestId: c.remoteId,
-		Data:   data,
+		Type:     message.MsgType_Default,
+		Id:       atomic.AddUint32(c.msgIdSeq, 1),
+		SrcId:    c.localId,
+		DestId:   c.remoteId,
+		Data:     data,
+		Metadata: message.MergeMetadata(md...),
 	})
 }
 
-func (c *Conn) RequestTo(ctx context.Context, dst uint32, data []byte) (int16, []byte, error) {
+func (c *Conn) RequestTo(ctx context.Context, dst uint32, data []byte, md ...message.Metadata) (int16, []byte, error) {
 	return c.RequestMessage(ctx, &message.Message{
-		Type:   message.MsgType_Default,
-		Id:     atomic.AddUint32(c.msgIdSeq, 1),
-		SrcId:  c.localId,
-		DestId: dst,
-		Data:   data,
+		Type:     message.MsgType_Default,
+		Id:       atomic.AddUint32(c.msgIdSeq, 1),
+		SrcId:    c.localId,
+		DestId:   dst,
+		Data:     data,
+		Metadata: message.MergeMetadata(md...),
 	})
 }
 
-func (c *Conn) RequestType(ctx context.Context, typ uint8, data []byte) (int16, []byte, error) {
+func (c *Conn) RequestType(ctx context.Context, typ uint8, data []byte, md ...message.Metadata) (int16, []byte, error) {
 	return c.RequestMessage(ctx, &message.Message{
-		Type:   typ,
-		Id:     atomic.AddUint32(c.msgIdSeq, 1),
-		SrcId:  c.localId,
-		DestId: c.remoteId,
-		Data:   data,
+		Type:     typ,
+		Id:       atomic.AddUint32(c.msgIdSeq, 1),
+		SrcId:    c.localId,
+		DestId:   c.remoteId,
+		Data:     data,
+		Metadata: message.MergeMetadata(md...),
 	})
 }
 
-func (c *Conn) RequestTypeTo(ctx context.Context, typ uint8, dst uint32, data []byte) (int16, []byte, error) {
+func (c *Conn) RequestTypeTo(ctx context.Context, typ uint8, dst uint32, data []byte, md ...message.Metadata) (int16, []byte, error) {
 	return c.RequestMessage(ctx, &message.Message{
-		Type:   typ,
-		Id:     atomic.AddUint32(c.msgIdSeq, 1),
-		SrcId:  c.localId,
-		DestId: dst,
-		Data:   data,
+		Type:     typ,
+		Id:       atomic.AddUint32(c.msgIdSeq, 1),
+		SrcId:    c.localId,
+		DestId:   dst,
+		Data:     data,
+		Metadata: message.MergeMetadata(md...),
 	})
 }
 
 func (c *Conn) RequestMessage(ctx context.Context, msg *message.Message) (int16, []byte, error) {
+	code, resp, err := c.RequestResponse(ctx, msg)
+	if resp == nil {
+		return code, nil, err
+	}
+	return code, resp.Data, err
+}
+
+// RequestResponse 同 RequestMessage，返回完整的响应消息，响应消息的Data不包含状态码，可用于获取响应的元数据
+func (c *Conn) RequestResponse(ctx context.Context, msg *message.Message) (int16, *message.Message, error) {
+	if c.IsGoAway() {
+		return 0, nil, errors.ErrGoAway
+	}
 	ch := make(chan *message.Message, 1)
 	id := msg.Id
 	c.revLock.Lock()
+	if c.closed {
+		c.revLock.Unlock()
+		return 0, nil, errors.ErrConnClosed
+	}
 	c.revChan[id] = ch
+	c.pending[id] = struct{}{}
 	c.revLock.Unlock()
+	if d, ok := ctx.Deadline(); ok && msg.Deadline == 0 {
+		msg.Deadline = d.UnixNano()
+	}
 	if err := c.SendMessage(msg); err != nil {
-		c.revLock.Lock()
-		delete(c.revChan, id)
-		c.revLock.Unlock()
-		close(ch)
+		c.removePending(id)
 		return 0, nil, err
 	}
 	select {
 	case <-ctx.Done():
-		c.revLock.Lock()
-		_ch, ok := c.revChan[id]
-		if ok {
-			close(_ch)
-			delete(c.revChan, id)
-		}
-		c.revLock.Unlock()
+		c.removePending(id)
+		// 通知目的节点取消该请求的处理，途经的节点会像普通消息一样转发
+		_ = c.SendMessage(&message.Message{
+			Type:   message.MsgType_Cancel,
+			Id:     id,
+			SrcId:  msg.SrcId,
+			DestId: msg.DestId,
+		})
 		return message.StateCode_RequestTimeout, nil, errors.Error(ctx.Err().Error())
 	case resp := <-ch:
-		close(ch)
+		c.removePending(id)
+		if resp == nil {
+			return 0, nil, errors.ErrConnClosed
+		}
 		if len(resp.Data) < 2 {
 			return message.StateCode_ResponseInvalid, nil, errors.ErrInvalidResponse
 		}
-		return int16(resp.Data[0]) | int16(resp.Data[1])<<8, resp.Data[2:], nil
+		code := int16(resp.Data[0]) | int16(resp.Data[1])<<8
+		resp.Data = resp.Data[2:]
+		return code, resp, nil
+	}
+}
+
+// OpenStream 向对端节点发起流，阻塞直至对端接受或拒绝
+func (c *Conn) OpenStream(ctx context.Context) (*stream.Stream, error) {
+	return c.OpenStreamTo(ctx, c.remoteId)
+}
+
+// OpenStreamTo 经过该连接向dst节点发起流，中间节点按普通消息转发
+func (c *Conn) OpenStreamTo(ctx context.Context, dst uint32) (*stream.Stream, error) {
+	if c.streams == nil || !c.peer.Supports(FeatureStream) {
+		return nil, errors.ErrStreamUnsupported
+	}
+	if c.IsGoAway() {
+		return nil, errors.ErrGoAway
+	}
+	if dst == c.localId {
+		return nil, errors.ErrWriteMsgYourself
+	}
+	return c.streams.Open(ctx, c, dst)
+}
+
+func (c *Conn) removePending(id uint32) {
+	c.revLock.Lock()
+	if _, ok := c.pending[id]; ok {
+		delete(c.pending, id)
+		delete(c.revChan, id)
+	}
+	c.revLock.Unlock()
+}
+
+// ReleasePending 连接断开后调用，该连接上所有等待响应的请求立即返回 errors.ErrConnClosed，之后的请求直接返回该错误
+func (c *Conn) ReleasePending() {
+	c.revLock.Lock()
+	c.closed = true
+	for id := range c.pending {
+		if ch, ok := c.revChan[id]; ok {
+			delete(c.revChan, id)
+			ch <- nil
+		}
+		delete(c.pending, id)
 	}
+	c.revLock.Unlock()
+}
+
+// SetGoAway 标记远程节点即将关闭，之后通过该连接发起的请求直接返回 errors.ErrGoAway
+func (c *Conn) SetGoAway() {
+	atomic.StoreUint32(&c.goAway, 1)
+}
+
+func (c *Conn) IsGoAway() bool {
+	return atomic.LoadUint32(&c.goAway) == 1
+}
+
+// Flush 阻塞直至写队列中的数据全部写出，未启用写队列时直接返回
+func (c *Conn) Flush(ctx context.Context) error {
+	if w, ok := c.w.(*bufwriter.Writer); ok {
+		return w.Flush(ctx)
+	}
+	return nil
 }
 
 func (c *Conn) Activate() time.Duration {
@@ -247,6 +521,69 @@ func (c *Conn) RemoteId() uint32 {
 	return c.remoteId
 }
 
+// PeerInfo 握手时对端声明的版本、功能和限制
+func (c *Conn) PeerInfo() PeerInfo {
+	return c.peer
+}
+
+// PeerCertificate 对端已验证的TLS证书，见 PeerCertificate 函数
+func (c *Conn) PeerCertificate() *x509.Certificate {
+	return PeerCertificate(c.conn)
+}
+
+// PeerCertificate 返回TLS连接对端已验证证书链的叶子证书，非TLS连接、握手未完成、对端未提供证书或证书未经验证
+// （例如 tls.RequireAnyClientCert、InsecureSkipVerify）时返回nil
+func PeerCertificate(c net.Conn) *x509.Certificate {
+	tc, ok := c.(*tls.Conn)
+	if !ok {
+		return nil
+	}
+	if chains := tc.ConnectionState().VerifiedChains; len(chains) > 0 && len(chains[0]) > 0 {
+		return chains[0][0]
+	}
+	return nil
+}
+
+// Identity 认证时确认的对端身份，未设置时为nil
+func (c *Conn) Identity() *Identity {
+	return c.identity
+}
+
+// PeerMetadata 对端在握手中发送的元数据，未经认证，不要用于授权，返回的map不应被修改
+func (c *Conn) PeerMetadata() map[string]string {
+	return c.peerMetadata
+}
+
+// SetAttr 设置会话属性，可以并发调用，属性随连接释放，认证时写入 Identity.Attributes
+func (c *Conn) SetAttr(key string, value any) {
+	c.attrs.Store(key, value)
+}
+
+// Attr 获取会话属性
+func (c *Conn) Attr(key string) (any, bool) {
+	return c.attrs.Load(key)
+}
+
+func (c *Conn) DeleteAttr(key string) {
+	c.attrs.Delete(key)
+}
+
+// RangeAttr 遍历会话属性，f返回false时停止
+func (c *Conn) RangeAttr(f func(key string, value any) bool) {
+	c.attrs.Range(func(k, v any) bool {
+		return f(k.(string), v)
+	})
+}
+
+// Integrity 连接协商的消息完整性校验方式
+func (c *Conn) Integrity() Integrity {
+	return c.integrity
+}
+
+func (c *Conn) MaxMsgLen() uint32 {
+	return c.maxMsgLen
+}
+
 func (c *Conn) CreateMessage(typ uint8, src uint32, dst uint32, data []byte) *message.Message {
 	return &message.Message{
 		Type:   typ,
@@ -262,7 +599,21 @@ func (c *Conn) CreateMessageId() uint32 {
 }
 
 func (c *Conn) Close() error {
-	return c.w.Close()
+	if c.w != io.WriteCloser(c.conn) {
+		_ = c.w.Close()
+	}
+	return c.conn.Close()
+}
+
+// CloseWithError 关闭连接，之后 ReadMessage 返回err，OnClose 回调收到的错误即为err
+func (c *Conn) CloseWithError(err error) error {
+	c.closeErr.CompareAndSwap(nil, closeReason{err: err})
+	return c.Close()
+}
+
+// closeReason atomic.Value 要求存储相同的类型
+type closeReason struct {
+	err error
 }
 
 func (c *Conn) LocalAddr() net.Addr {
@@ -277,6 +628,29 @@ func (c *Conn) ConnType() Type {
 	return c.typ
 }
 
+// Integrity 消息完整性校验方式，建立连接时协商，取双方中较强的一种
+type Integrity uint8
+
+const (
+	// IntegrityLegacy 仅校验消息头的16位累加和
+	IntegrityLegacy Integrity = iota
+	// IntegrityCRC32C 在消息头累加和之外，消息末尾追加4Byte覆盖消息头和消息体的CRC32-C（Castagnoli）校验和
+	IntegrityCRC32C
+)
+
+var crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+
+func (i Integrity) String() string {
+	switch i {
+	case IntegrityLegacy:
+		return "legacy"
+	case IntegrityCRC32C:
+		return "crc32c"
+	default:
+		return "unknown"
+	}
+}
+
 type Type uint8
 
 const (
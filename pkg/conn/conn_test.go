@@ -0,0 +1,216 @@
+package conn
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"github.com/Li-giegie/node/pkg/compress"
+	"github.com/Li-giegie/node/pkg/errors"
+	"github.com/Li-giegie/node/pkg/message"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestConnExtension(t *testing.T) {
+	a, b := net.Pipe()
+	defer a.Close()
+	defer b.Close()
+	var seq uint32
+	var l sync.Mutex
+	peer := WithPeerInfo(PeerInfo{Features: Features})
+	ca := NewConn(TypeClient, 1, 2, a, map[uint32]chan *message.Message{}, &l, &seq, 0, 0, 0, 0, peer)
+	cb := NewConn(TypeClient, 2, 1, b, map[uint32]chan *message.Message{}, &l, &seq, 0, 0, 0, 0, peer)
+	deadline := time.Now().Add(time.Second).UnixNano()
+	go func() {
+		_ = ca.SendMessage(&message.Message{Id: 1, SrcId: 1, DestId: 2, Data: []byte("hello"), Deadline: deadline, Metadata: message.Metadata{"trace-id": "abc", "empty": ""}})
+		_ = ca.SendMessage(&message.Message{Id: 2, SrcId: 1, DestId: 2, Data: []byte("world"), NoReply: true})
+	}()
+	m, err := cb.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(m.Data) != "hello" || m.Deadline < deadline-int64(time.Millisecond*100) || m.Deadline > deadline+int64(time.Millisecond*100) {
+		t.Fatal("invalid message", m.String(), m.Deadline-deadline)
+	}
+	if len(m.Metadata) != 2 || m.Metadata.Get("trace-id") != "abc" || m.NoReply {
+		t.Fatal("invalid metadata", m.Metadata)
+	}
+	if m, err = cb.ReadMessage(); err != nil {
+		t.Fatal(err)
+	}
+	if string(m.Data) != "world" || m.Deadline != 0 || m.Metadata != nil || !m.NoReply {
+		t.Fatal("invalid message", m.String())
+	}
+}
+
+// 对端未声明 FeatureHeaderFlags 时使用旧版本的消息头，不发送扩展
+func TestConnLegacyHeader(t *testing.T) {
+	var seq uint32
+	var l sync.Mutex
+	src, dst := new(bufConn), new(bufConn)
+	peer := WithPeerInfo(PeerInfo{Version: 1, Codecs: []uint8{compress.CodecDeflate}})
+	ca := NewConn(TypeClient, 1, 2, src, map[uint32]chan *message.Message{}, &l, &seq, 0, 0, 0, 0, peer, WithCompressor(compress.Deflate, 0))
+	cb := NewConn(TypeClient, 2, 1, dst, map[uint32]chan *message.Message{}, &l, &seq, 0, 0, 0, 0, peer)
+	data := bytes.Repeat([]byte("0123456789"), 100)
+	_ = ca.SendMessage(&message.Message{Id: 1, SrcId: 1, DestId: 2, Data: data, Deadline: time.Now().Add(time.Second).UnixNano(), Metadata: message.Metadata{"k": "v"}, NoReply: true})
+	if src.w.Len() != message.MsgHeaderLen+len(data) {
+		t.Fatal("invalid length", src.w.Len())
+	}
+	raw := src.w.Bytes()
+	if binary.LittleEndian.Uint32(raw[2:6]) != 1 || binary.LittleEndian.Uint32(raw[14:18]) != uint32(len(data)) {
+		t.Fatal("invalid header", raw[:message.MsgHeaderLen])
+	}
+	dst.r.Write(raw)
+	m, err := cb.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Id != 1 || !bytes.Equal(m.Data, data) || m.Deadline != 0 || m.Metadata != nil || m.NoReply {
+		t.Fatal("invalid message", m.String())
+	}
+}
+
+// bufConn 读写内存缓冲区的连接
+type bufConn struct {
+	net.Conn
+	r bytes.Buffer
+	w bytes.Buffer
+}
+
+func (b *bufConn) Read(p []byte) (int, error) {
+	return b.r.Read(p)
+}
+
+func (b *bufConn) Write(p []byte) (int, error) {
+	return b.w.Write(p)
+}
+
+func TestConnCRC32C(t *testing.T) {
+	var seq uint32
+	var l sync.Mutex
+	src, dst := new(bufConn), new(bufConn)
+	ca := NewConn(TypeClient, 1, 2, src, map[uint32]chan *message.Message{}, &l, &seq, 0, 0, 0, 0, WithIntegrity(IntegrityCRC32C))
+	cb := NewConn(TypeClient, 2, 1, dst, map[uint32]chan *message.Message{}, &l, &seq, 0, 0, 0, 0, WithIntegrity(IntegrityCRC32C))
+	_ = ca.SendMessage(&message.Message{Id: 1, SrcId: 1, DestId: 2, Data: []byte("corrupted")})
+	_ = ca.SendMessage(&message.Message{Id: 2, SrcId: 1, DestId: 2, Data: []byte("hello")})
+	raw := src.w.Bytes()
+	// 修改第一条消息的消息体，消息头累加和仍然有效
+	raw[message.MsgHeaderLen] ^= 0xff
+	dst.r.Write(raw)
+	m, err := cb.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Id != 2 || string(m.Data) != "hello" {
+		t.Fatal("invalid message", m.String())
+	}
+	// 接收方应回复 message.StateCode_CheckSumInvalid
+	src.r.Write(dst.w.Bytes())
+	if m, err = ca.ReadMessage(); err != nil {
+		t.Fatal(err)
+	}
+	if m.Type != message.MsgType_Response || m.Id != 1 || int16(m.Data[0])|int16(m.Data[1])<<8 != message.StateCode_CheckSumInvalid {
+		t.Fatal("invalid response", m.String())
+	}
+}
+
+func TestConnCompression(t *testing.T) {
+	var seq uint32
+	var l sync.Mutex
+	src, dst := new(bufConn), new(bufConn)
+	peer := WithPeerInfo(PeerInfo{Features: Features, Codecs: []uint8{compress.CodecDeflate}})
+	ca := NewConn(TypeClient, 1, 2, src, map[uint32]chan *message.Message{}, &l, &seq, 0, 0, 0, 0, peer, WithCompressor(compress.Deflate, 0))
+	cb := NewConn(TypeServer, 2, 1, dst, map[uint32]chan *message.Message{}, &l, &seq, 0, 0, 0, 0, peer)
+	data := bytes.Repeat([]byte("0123456789"), 1000)
+	_ = ca.SendMessage(&message.Message{Id: 1, SrcId: 1, DestId: 2, Data: data})
+	_ = ca.SendMessage(&message.Message{Id: 2, SrcId: 1, DestId: 3, Data: data})
+	if src.w.Len() >= len(data) {
+		t.Fatal("not compressed", src.w.Len())
+	}
+	dst.r.Write(src.w.Bytes())
+	// 发给本节点的消息透明解压
+	m, err := cb.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Compression != 0 || !bytes.Equal(m.Data, data) {
+		t.Fatal("invalid message", m.Compression, len(m.Data))
+	}
+	// 转发的消息保持压缩，原样发送
+	if m, err = cb.ReadMessage(); err != nil {
+		t.Fatal(err)
+	}
+	if m.Compression != compress.CodecDeflate || len(m.Data) >= len(data) {
+		t.Fatal("invalid message", m.Compression, len(m.Data))
+	}
+	n := len(m.Data)
+	_ = cb.SendMessage(m)
+	if dst.w.Len() != message.MsgHeaderLenFlags+1+n {
+		t.Fatal("recompressed", dst.w.Len())
+	}
+}
+
+func TestConnAttr(t *testing.T) {
+	var seq uint32
+	var l sync.Mutex
+	c := NewConn(TypeClient, 1, 2, new(bufConn), map[uint32]chan *message.Message{}, &l, &seq, 0, 0, 0, 0,
+		WithPeerMetadata(map[string]string{"version": "1.0", "tenant": "spoofed"}),
+		WithIdentity(&Identity{Subject: "alice", Attributes: map[string]string{"tenant": "t1"}}),
+	)
+	// 握手元数据不写入会话属性
+	if md := c.PeerMetadata(); md["version"] != "1.0" || md["tenant"] != "spoofed" {
+		t.Fatalf("peer metadata = %v", md)
+	}
+	if _, ok := c.Attr("version"); ok {
+		t.Fatal("peer metadata merged into attrs")
+	}
+	if v, _ := c.Attr("tenant"); v != "t1" {
+		t.Fatalf("tenant = %v", v)
+	}
+	c.SetAttr("user", 7)
+	c.DeleteAttr("tenant")
+	n := 0
+	c.RangeAttr(func(key string, value any) bool {
+		n++
+		return true
+	})
+	if _, ok := c.Attr("tenant"); ok || n != 1 {
+		t.Fatalf("invalid attrs, n = %d", n)
+	}
+}
+
+func TestConnReleasePending(t *testing.T) {
+	a, b := net.Pipe()
+	defer b.Close()
+	go io.Copy(io.Discard, b)
+	var seq uint32
+	var l sync.Mutex
+	c := NewConn(TypeClient, 1, 2, a, map[uint32]chan *message.Message{}, &l, &seq, 0, 0, 0, 0)
+	errC := make(chan error, 1)
+	go func() {
+		_, _, err := c.Request(context.Background(), []byte("ping"))
+		errC <- err
+	}()
+	for n := 0; n == 0; {
+		time.Sleep(time.Millisecond)
+		l.Lock()
+		n = len(c.pending)
+		l.Unlock()
+	}
+	_ = c.Close()
+	c.ReleasePending()
+	select {
+	case err := <-errC:
+		if err == nil || err.Error() != errors.ErrConnClosed.Error() {
+			t.Fatalf("pending request err = %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("pending request not released")
+	}
+	if _, _, err := c.Request(context.Background(), []byte("ping")); err == nil || err.Error() != errors.ErrConnClosed.Error() {
+		t.Fatalf("request after close err = %v", err)
+	}
+}
@@ -0,0 +1,121 @@
+package conn
+
+import (
+	"encoding/binary"
+	"github.com/Li-giegie/node/pkg/errors"
+	"github.com/Li-giegie/node/pkg/message"
+	"time"
+)
+
+// extensionLen 计算消息需要的扩展字段及其长度，codec为发送时Data的压缩算法编号
+func extensionLen(m *message.Message, codec uint8) (flags uint8, n int, err error) {
+	if m.Deadline != 0 {
+		flags |= message.FlagDeadline
+		n += 8
+	}
+	if len(m.Metadata) > 0 {
+		if len(m.Metadata) > 0xffff {
+			return 0, 0, errors.ErrLengthOverflow
+		}
+		flags |= message.FlagMetadata
+		n += 2
+		for k, v := range m.Metadata {
+			if len(k) > 0xffff || len(v) > 0xffff {
+				return 0, 0, errors.ErrLengthOverflow
+			}
+			n += 4 + len(k) + len(v)
+		}
+	}
+	if codec != 0 {
+		flags |= message.FlagCompressed
+		n++
+	}
+	if m.NoReply {
+		flags |= message.FlagNoReply
+	}
+	return flags, n, nil
+}
+
+// encodeExtension 按flags将扩展字段写入b，返回写入的长度
+func encodeExtension(m *message.Message, flags, codec uint8, b []byte) int {
+	var n int
+	if flags&message.FlagDeadline != 0 {
+		// 传输剩余时长而不是绝对时间，避免依赖两端时钟同步，已过期的按1纳秒发送使对端立即超时
+		remain := m.Deadline - time.Now().UnixNano()
+		if remain <= 0 {
+			remain = 1
+		}
+		binary.LittleEndian.PutUint64(b[n:], uint64(remain))
+		n += 8
+	}
+	if flags&message.FlagMetadata != 0 {
+		binary.LittleEndian.PutUint16(b[n:], uint16(len(m.Metadata)))
+		n += 2
+		for k, v := range m.Metadata {
+			binary.LittleEndian.PutUint16(b[n:], uint16(len(k)))
+			n += 2
+			n += copy(b[n:], k)
+			binary.LittleEndian.PutUint16(b[n:], uint16(len(v)))
+			n += 2
+			n += copy(b[n:], v)
+		}
+	}
+	if flags&message.FlagCompressed != 0 {
+		b[n] = codec
+		n++
+	}
+	return n
+}
+
+// decodeExtension 解析位于Data之前的扩展字段
+func decodeExtension(m *message.Message, flags uint8) error {
+	if flags&message.FlagDeadline != 0 {
+		if len(m.Data) < 8 {
+			return errors.ErrInvalidMessage
+		}
+		m.Deadline = time.Now().UnixNano() + int64(binary.LittleEndian.Uint64(m.Data))
+		m.Data = m.Data[8:]
+	}
+	if flags&message.FlagMetadata != 0 {
+		if len(m.Data) < 2 {
+			return errors.ErrInvalidMessage
+		}
+		count := int(binary.LittleEndian.Uint16(m.Data))
+		m.Data = m.Data[2:]
+		m.Metadata = make(message.Metadata, count)
+		var k, v string
+		var ok bool
+		for i := 0; i < count; i++ {
+			if k, ok = readString16(&m.Data); !ok {
+				return errors.ErrInvalidMessage
+			}
+			if v, ok = readString16(&m.Data); !ok {
+				return errors.ErrInvalidMessage
+			}
+			m.Metadata[k] = v
+		}
+	}
+	if flags&message.FlagCompressed != 0 {
+		if len(m.Data) < 1 || m.Data[0] == 0 {
+			return errors.ErrInvalidMessage
+		}
+		m.Compression = m.Data[0]
+		m.Data = m.Data[1:]
+	}
+	m.NoReply = flags&message.FlagNoReply != 0
+	return nil
+}
+
+// readString16 读取2Byte长度前缀的字符串
+func readString16(b *[]byte) (string, bool) {
+	if len(*b) < 2 {
+		return "", false
+	}
+	n := int(binary.LittleEndian.Uint16(*b))
+	if len(*b) < 2+n {
+		return "", false
+	}
+	s := string((*b)[2 : 2+n])
+	*b = (*b)[2+n:]
+	return s, true
+}
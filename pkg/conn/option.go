@@ -0,0 +1,57 @@
+package conn
+
+import (
+	"github.com/Li-giegie/node/pkg/compress"
+	"github.com/Li-giegie/node/pkg/stream"
+)
+
+type Option func(*Conn)
+
+// WithStreamManager 设置连接所属节点的流管理器，未设置时该连接不支持 Conn.OpenStream
+func WithStreamManager(m *stream.Manager) Option {
+	return func(c *Conn) {
+		c.streams = m
+	}
+}
+
+// WithIntegrity 设置连接的消息完整性校验方式，两端必须一致
+func WithIntegrity(i Integrity) Option {
+	return func(c *Conn) {
+		c.integrity = i
+	}
+}
+
+// WithPeerInfo 设置握手时对端声明的信息
+func WithPeerInfo(p PeerInfo) Option {
+	return func(c *Conn) {
+		c.peer = p
+	}
+}
+
+// WithCompressor 设置发送消息使用的压缩算法，Data长度不小于threshold时压缩，threshold为0时使用 compress.DefaultThreshold，
+// 对端未声明支持该算法时不压缩
+func WithCompressor(cp compress.Compressor, threshold int) Option {
+	return func(c *Conn) {
+		c.compressor = cp
+		c.compressThreshold = threshold
+	}
+}
+
+// WithIdentity 设置认证时确认的对端身份，Attributes 写入会话属性
+func WithIdentity(id *Identity) Option {
+	return func(c *Conn) {
+		c.identity = id
+		if id != nil {
+			for k, v := range id.Attributes {
+				c.attrs.Store(k, v)
+			}
+		}
+	}
+}
+
+// WithPeerMetadata 设置对端在握手中发送的元数据，元数据由对端提供，不写入会话属性
+func WithPeerMetadata(md map[string]string) Option {
+	return func(c *Conn) {
+		c.peerMetadata = md
+	}
+}
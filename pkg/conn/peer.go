@@ -0,0 +1,73 @@
+package conn
+
+// Feature 握手时声明支持的可选功能
+type Feature uint32
+
+const (
+	// FeatureDeadline 请求携带截止时间
+	FeatureDeadline Feature = 1 << iota
+	// FeatureCancel 支持 message.MsgType_Cancel 取消请求
+	FeatureCancel
+	// FeatureMetadata 消息携带元数据
+	FeatureMetadata
+	// FeatureStream 支持 stream 包的流
+	FeatureStream
+	// FeatureHeaderFlags 消息头携带Flags字段及扩展，未协商时使用旧版本的消息头，不发送截止时间、元数据，不压缩
+	FeatureHeaderFlags
+	// FeatureGoAway 支持 message.MsgType_GoAway
+	FeatureGoAway
+)
+
+// Features 当前实现支持的全部功能
+const Features = FeatureDeadline | FeatureCancel | FeatureMetadata | FeatureStream | FeatureHeaderFlags | FeatureGoAway
+
+// PeerInfo 握手时对端声明的版本、功能和限制，v1握手的对端只有Version和MaxMsgLen有效，不支持任何 Feature
+type PeerInfo struct {
+	// 握手协议版本
+	Version uint8
+	// 支持的功能
+	Features Feature
+	// 支持的协议消息类型
+	Protocols []uint8
+	// 对端允许接收的消息最大长度，0为不限制
+	MaxMsgLen uint32
+	// 对端可以解压的压缩算法编号
+	Codecs []uint8
+}
+
+func (p *PeerInfo) Supports(f Feature) bool {
+	return p.Features&f == f
+}
+
+func (p *PeerInfo) SupportsProtocol(typ uint8) bool {
+	for _, t := range p.Protocols {
+		if t == typ {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *PeerInfo) SupportsCodec(codec uint8) bool {
+	for _, c := range p.Codecs {
+		if c == codec {
+			return true
+		}
+	}
+	return false
+}
+
+// Identity 认证时 Authenticator 确认的对端身份
+type Identity struct {
+	// 身份名称，例如用户名、证书的CommonName
+	Subject string
+	// 身份属性，例如角色、租户
+	Attributes map[string]string
+}
+
+func (i *Identity) Get(key string) string {
+	if i == nil {
+		return ""
+	}
+	return i.Attributes[key]
+}
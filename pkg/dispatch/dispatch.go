@@ -0,0 +1,130 @@
+package dispatch
+
+import (
+	"runtime"
+	"sync"
+)
+
+// Mode 消息分发模式
+type Mode uint8
+
+const (
+	// ModeInline 在连接的读循环中同步调用，处理器阻塞时该连接后续的消息都会被阻塞，处理期间无法收到 message.MsgType_Cancel，只有截止时间生效
+	ModeInline Mode = iota
+	// ModeGoroutine 每条消息启动一个goroutine处理
+	ModeGoroutine
+	// ModePool 由固定数量的工作协程处理，队列满时读循环阻塞等待
+	ModePool
+)
+
+func (m Mode) String() string {
+	switch m {
+	case ModeInline:
+		return "inline"
+	case ModeGoroutine:
+		return "goroutine"
+	case ModePool:
+		return "pool"
+	default:
+		return "invalid mode"
+	}
+}
+
+// New 创建分发器，workers、queueSize仅在ModePool时有效，workers小于等于0时为CPU核数，
+// ordered为true时相同key的任务由同一个工作协程按顺序执行
+func New(mode Mode, workers, queueSize int, ordered bool) *Dispatcher {
+	d := &Dispatcher{mode: mode, done: make(chan struct{})}
+	if mode != ModePool {
+		return d
+	}
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	if queueSize < 0 {
+		queueSize = 0
+	}
+	if !ordered {
+		d.queues = []chan func(){make(chan func(), queueSize)}
+		for i := 0; i < workers; i++ {
+			go d.work(d.queues[0])
+		}
+		return d
+	}
+	d.queues = make([]chan func(), workers)
+	for i := range d.queues {
+		d.queues[i] = make(chan func(), queueSize/workers)
+		go d.work(d.queues[i])
+	}
+	return d
+}
+
+// Dispatcher 消息分发器，nil或已关闭的分发器同步执行任务
+type Dispatcher struct {
+	mode   Mode
+	queues []chan func()
+	done   chan struct{}
+	// lock 保证Close之后不再有任务进入队列，否则工作协程退出后入队的任务将丢失
+	lock   sync.RWMutex
+	closed bool
+}
+
+// Dispatch 按分发模式执行f，key用于ModePool有序分发时选择工作协程，通常为消息的源节点Id
+func (d *Dispatcher) Dispatch(key uint32, f func()) {
+	if d == nil {
+		f()
+		return
+	}
+	switch d.mode {
+	case ModeGoroutine:
+		go f()
+	case ModePool:
+		d.lock.RLock()
+		if d.closed {
+			d.lock.RUnlock()
+			f()
+			return
+		}
+		d.queues[int(key%uint32(len(d.queues)))] <- f
+		d.lock.RUnlock()
+	default:
+		f()
+	}
+}
+
+func (d *Dispatcher) work(queue chan func()) {
+	for {
+		select {
+		case f := <-queue:
+			f()
+		case <-d.done:
+			for {
+				select {
+				case f := <-queue:
+					f()
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// Close 关闭分发器，工作协程处理完队列中剩余的任务后退出，不可在任务中调用
+func (d *Dispatcher) Close() {
+	if d == nil {
+		return
+	}
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if !d.closed {
+		d.closed = true
+		close(d.done)
+	}
+}
+
+func (d *Dispatcher) Mode() Mode {
+	if d == nil {
+		return ModeInline
+	}
+	return d.mode
+}
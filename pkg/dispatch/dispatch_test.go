@@ -0,0 +1,79 @@
+package dispatch
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDispatcherOrdered(t *testing.T) {
+	d := New(ModePool, 4, 64, true)
+	defer d.Close()
+	var wg sync.WaitGroup
+	var l sync.Mutex
+	result := make(map[uint32][]int)
+	for i := 0; i < 1000; i++ {
+		key := uint32(i % 7)
+		n := i
+		wg.Add(1)
+		d.Dispatch(key, func() {
+			defer wg.Done()
+			l.Lock()
+			result[key] = append(result[key], n)
+			l.Unlock()
+		})
+	}
+	wg.Wait()
+	for key, list := range result {
+		for i := 1; i < len(list); i++ {
+			if list[i] < list[i-1] {
+				t.Errorf("key %d out of order: %v", key, list)
+				break
+			}
+		}
+	}
+}
+
+func TestDispatcherClosed(t *testing.T) {
+	d := New(ModePool, 2, 0, false)
+	d.Close()
+	var called bool
+	d.Dispatch(0, func() { called = true })
+	if !called {
+		t.Error("closed dispatcher should run task inline")
+	}
+	var nilDispatcher *Dispatcher
+	called = false
+	nilDispatcher.Dispatch(0, func() { called = true })
+	if !called {
+		t.Error("nil dispatcher should run task inline")
+	}
+}
+
+func TestDispatcherCloseRace(t *testing.T) {
+	for i := 0; i < 500; i++ {
+		d := New(ModePool, 4, 16, false)
+		var n int64
+		var wg sync.WaitGroup
+		for j := 0; j < 8; j++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for k := 0; k < 25; k++ {
+					d.Dispatch(uint32(k), func() { atomic.AddInt64(&n, 1) })
+				}
+			}()
+		}
+		d.Close()
+		wg.Wait()
+		// 入队的任务由工作协程异步执行，等待其处理完剩余的队列
+		deadline := time.Now().Add(time.Second)
+		for atomic.LoadInt64(&n) != 200 && time.Now().Before(deadline) {
+			time.Sleep(time.Millisecond)
+		}
+		if got := atomic.LoadInt64(&n); got != 200 {
+			t.Fatalf("executed %d tasks, want 200", got)
+		}
+	}
+}
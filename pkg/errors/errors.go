@@ -1,5 +1,7 @@
 package errors
 
+import "time"
+
 type NodeError interface {
 	Error() string
 	NodeError()
@@ -21,8 +23,40 @@ var (
 	ErrLengthOverflow      = Error("length overflow")
 	ErrNodeNotExist        = Error("node not exist")
 	BridgeRemoteIdExistErr = Error("Bridge error: remote id exist")
+	ErrAddressEmpty        = Error("address list is empty")
+	ErrConnClosed          = Error("connection closed")
+	ErrGoAway              = Error("remote node is going away")
+	ErrInvalidMessage      = Error("invalid message")
+	ErrStreamRefused       = Error("stream refused")
+	ErrStreamReset         = Error("stream reset by remote node")
+	ErrStreamClosed        = Error("stream closed")
+	ErrStreamTimeout       = Error("stream idle timeout")
+	ErrStreamUnsupported   = Error("stream is not supported on this connection")
+	ErrCodecUnsupported    = Error("compression codec unsupported")
+	ErrAuthRemoteInvalid   = Error("remote node failed authentication")
+	ErrKeyRequired         = Error("key authentication required")
+	ErrCertRequired        = Error("peer certificate required")
+	ErrCertMismatch        = Error("peer certificate does not match node id")
+	ErrConnReplaced        = Error("connection replaced by a new session of the same node")
+	ErrIdExhausted         = Error("no node id available for assignment")
+	ErrFeatureUnsupported  = Error("message type is not supported by the remote node")
+	ErrRevoked             = Error("node credentials revoked")
 )
 
 func New(s string) error {
 	return Error([]byte(s))
 }
+
+// ServerBusyError 对端连接数已满拒绝了连接，RetryAfter为对端建议的重试间隔，0为未指定
+type ServerBusyError struct {
+	RetryAfter time.Duration
+}
+
+func (e *ServerBusyError) Error() string {
+	if e.RetryAfter > 0 {
+		return "server busy, retry after " + e.RetryAfter.String()
+	}
+	return "server busy"
+}
+
+func (e *ServerBusyError) NodeError() {}
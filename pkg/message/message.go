@@ -11,18 +11,39 @@ const (
 	MsgType_Undefined
 )
 
+// 扩展的内部消息类型，取值固定在类型空间的末尾，不影响 MsgType_Undefined 及在其之后分配的协议类型
+const (
+	MsgType_Stream uint8 = 253 // 流帧，见 stream 包
+	MsgType_Cancel uint8 = 254 // 请求方已放弃等待，取消Id相同的请求在对端的处理
+	MsgType_GoAway uint8 = 255 // 节点即将关闭，收到后不应再通过该连接发起新的请求
+)
+
 const (
 	StateCode_CheckSumInvalid int16 = 100 + iota
 	StateCode_RequestTimeout
 	StateCode_LengthOverflow
 	StateCode_Success            int16 = 200
 	StateCode_ResponseInvalid    int16 = 204
+	StateCode_Forbidden          int16 = 403
 	StateCode_NodeNotExist       int16 = 404
+	StateCode_CodecUnsupported   int16 = 415
+	StateCode_InternalError      int16 = 500
 	StateCode_MessageTypeInvalid int16 = 600
 )
 
 const MsgHeaderLen = 1 + 1 + 4 + 4 + 4 + 4 + 2
 
+// MsgHeaderLenFlags 协商 conn.FeatureHeaderFlags 后的消息头长度，Hop之后增加1Byte Flags字段
+const MsgHeaderLenFlags = MsgHeaderLen + 1
+
+// 消息头Flags字段，置位的扩展按位从低到高依次位于Data之前，DataLength包含扩展的长度
+const (
+	FlagDeadline   uint8 = 1 << iota // 8Byte，请求剩余的超时时长（纳秒）
+	FlagMetadata                     // 2Byte键值对数量，每个键值对为 2Byte键长度+键+2Byte值长度+值
+	FlagCompressed                   // 1Byte压缩算法编号，扩展之后的Data为压缩后的数据，见 compress 包
+	FlagNoReply                      // 0Byte，单向消息，接收方不回复
+)
+
 type Message struct {
 	Type   uint8  //消息类型，用于特定功能（协议）而不是不同场景，不可滥用，Data字段能解决所有场景
 	Hop    uint8  //消息的跳数，初始值0，每经过一个节点加1
@@ -30,8 +51,43 @@ type Message struct {
 	SrcId  uint32 //源节点
 	DestId uint32 //目的节点
 	Data   []byte //消息数据
+	// 请求的截止时间（UnixNano），0表示没有截止时间，传输时编码为剩余时长，每跳重新计算
+	Deadline int64
+	// 元数据，例如链路追踪Id、认证令牌、内容类型等，不为空时随消息传输，转发时保留
+	Metadata Metadata
+	// Data的压缩算法编号，0表示未压缩，仅出现在转发的消息中：目的节点收到时已透明解压，转发时保持压缩避免重复压缩
+	Compression uint8
+	// 单向消息（Send系列方法发送），接收方不回复，例如ACL拒绝时不回复 StateCode_Forbidden，对端不支持Flags时不传输
+	NoReply bool
 }
 
 func (m *Message) String() string {
 	return fmt.Sprintf("type: %d, id: %v, srcId: %v, destId: %v, hop: %d, data: %s", m.Type, m.Id, m.SrcId, m.DestId, m.Hop, m.Data)
 }
+
+// Metadata 消息的元数据，键和值的长度不能超过65535
+type Metadata map[string]string
+
+func (md Metadata) Get(key string) string {
+	return md[key]
+}
+
+// MergeMetadata 合并多个元数据，相同的键后面的覆盖前面的，全部为空时返回nil
+func MergeMetadata(md ...Metadata) Metadata {
+	switch len(md) {
+	case 0:
+		return nil
+	case 1:
+		return md[0]
+	}
+	var out Metadata
+	for _, m := range md {
+		for k, v := range m {
+			if out == nil {
+				out = make(Metadata)
+			}
+			out[k] = v
+		}
+	}
+	return out
+}
@@ -0,0 +1,86 @@
+package middleware
+
+import (
+	"github.com/Li-giegie/node/pkg/message"
+	"github.com/Li-giegie/node/pkg/reply"
+	"log"
+	"runtime/debug"
+	"time"
+)
+
+// HandlerFunc 消息处理函数，与 server.OnMessageFunc、client.OnMessageFunc 签名一致
+type HandlerFunc func(r *reply.Reply, m *message.Message) (next bool)
+
+// Middleware 包装消息处理函数，调用next前可直接回复状态码并返回以中断处理，next返回后可执行后置逻辑
+type Middleware func(next HandlerFunc) HandlerFunc
+
+// Chain 将多个中间件组合为一个，第一个中间件位于最外层
+func Chain(mws ...Middleware) Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		for i := len(mws) - 1; i >= 0; i-- {
+			next = mws[i](next)
+		}
+		return next
+	}
+}
+
+// Logging 处理完成后输出消息概要及耗时，l为nil时使用 log.Default()
+func Logging(l *log.Logger) Middleware {
+	if l == nil {
+		l = log.Default()
+	}
+	return func(next HandlerFunc) HandlerFunc {
+		return func(r *reply.Reply, m *message.Message) bool {
+			start := time.Now()
+			ok := next(r, m)
+			l.Printf("type: %d, id: %d, srcId: %d, destId: %d, len: %d, cost: %v", m.Type, m.Id, m.SrcId, m.DestId, len(m.Data), time.Since(start))
+			return ok
+		}
+	}
+}
+
+// Timing 处理完成后回调f，d为处理耗时
+func Timing(f func(m *message.Message, d time.Duration)) Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(r *reply.Reply, m *message.Message) bool {
+			start := time.Now()
+			ok := next(r, m)
+			f(m, time.Since(start))
+			return ok
+		}
+	}
+}
+
+// Recovery 捕获处理函数中的panic并回复 message.StateCode_InternalError，f不为nil时回调panic值及堆栈，否则输出到标准日志
+func Recovery(f func(m *message.Message, v any, stack []byte)) Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(r *reply.Reply, m *message.Message) (ok bool) {
+			defer func() {
+				if v := recover(); v != nil {
+					stack := debug.Stack()
+					_ = r.Write(message.StateCode_InternalError, nil)
+					if f != nil {
+						f(m, v, stack)
+					} else {
+						log.Printf("panic: %v, message: %s\n%s", v, m.String(), stack)
+					}
+					ok = false
+				}
+			}()
+			return next(r, m)
+		}
+	}
+}
+
+// MaxDataLen 消息数据长度超过n时回复 message.StateCode_LengthOverflow 并中断处理
+func MaxDataLen(n int) Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(r *reply.Reply, m *message.Message) bool {
+			if len(m.Data) > n {
+				_ = r.Write(message.StateCode_LengthOverflow, nil)
+				return false
+			}
+			return next(r, m)
+		}
+	}
+}
@@ -0,0 +1,40 @@
+package middleware
+
+import (
+	"github.com/Li-giegie/node/pkg/message"
+	"github.com/Li-giegie/node/pkg/reply"
+	"reflect"
+	"testing"
+)
+
+func TestChain(t *testing.T) {
+	var trace []string
+	mw := func(name string, abort bool) Middleware {
+		return func(next HandlerFunc) HandlerFunc {
+			return func(r *reply.Reply, m *message.Message) bool {
+				trace = append(trace, name+" before")
+				if abort {
+					return false
+				}
+				ok := next(r, m)
+				trace = append(trace, name+" after")
+				return ok
+			}
+		}
+	}
+	h := func(r *reply.Reply, m *message.Message) bool {
+		trace = append(trace, "handler")
+		return true
+	}
+	Chain(mw("a", false), mw("b", false))(h)(nil, &message.Message{})
+	want := []string{"a before", "b before", "handler", "b after", "a after"}
+	if !reflect.DeepEqual(trace, want) {
+		t.Errorf("got %v, want %v", trace, want)
+	}
+	trace = nil
+	Chain(mw("a", false), mw("b", true))(h)(nil, &message.Message{})
+	want = []string{"a before", "b before", "a after"}
+	if !reflect.DeepEqual(trace, want) {
+		t.Errorf("got %v, want %v", trace, want)
+	}
+}
@@ -22,6 +22,7 @@ func NewRouterBFS(protoType uint8, node node.Server) *RouterBFS {
 		init:          true,
 	}
 	node.GetRouter().ReroutingHandleFunc(p.CalcRoute)
+	node.GetRouter().ReachableHandleFunc(p.ReachableVia)
 	return p
 }
 
@@ -66,7 +67,7 @@ func (p *RouterBFS) onConnect(c *conn.Conn) {
 			},
 		}).Encode(),
 	})
-	if c.ConnType() == conn.TypeServer {
+	if c.ConnType() == conn.TypeServer && p.supports(c) {
 		c.SendType(p.protoType, (&ProtoMsg{
 			Id:     atomic.AddInt64(&p.idCounter, 1),
 			Action: Action_NeighborASK,
@@ -348,6 +349,12 @@ func (p *RouterBFS) removeRoute(id uint32) {
 	}
 }
 
+// supports 对端在握手中声明了协议消息类型时，只向声明了本协议的对端发送，未声明时（v1握手或未设置）总是发送
+func (p *RouterBFS) supports(c *conn.Conn) bool {
+	info := c.PeerInfo()
+	return len(info.Protocols) == 0 || info.SupportsProtocol(p.protoType)
+}
+
 func (p *RouterBFS) broadcast(hop uint8, msg *ProtoMsg) {
 	num := p.neighborTable.Len()
 	if num == 0 {
@@ -360,7 +367,7 @@ func (p *RouterBFS) broadcast(hop uint8, msg *ProtoMsg) {
 	var conns = make([]*conn.Conn, 0, num)
 	var connIds = make([]uint32, 0, num)
 	p.RangeNeighbor(func(id uint32, conn *conn.Conn) bool {
-		if _, ok := filterIds[id]; !ok {
+		if _, ok := filterIds[id]; !ok && p.supports(conn) {
 			connIds = append(connIds, id)
 			conns = append(conns, conn)
 		}
@@ -389,12 +396,20 @@ type bfsResult struct {
 
 // BFSSearch 搜索起点到终端的路径，src起点，dst终点，maxDeep最大深度，返回起点到终点的全部路径，bool是否存在
 func (p *RouterBFS) BFSSearch(src, dst uint32, maxDeep uint8) (result []uint32) {
+	return p.bfsSearch(src, dst, maxDeep)
+}
+
+// bfsSearch 同 BFSSearch，路径不经过exclude节点
+func (p *RouterBFS) bfsSearch(src, dst uint32, maxDeep uint8, exclude ...uint32) (result []uint32) {
 	if src == dst {
 		return nil
 	}
 	queue := make([]*bfsResult, 1, 10)
 	queue[0] = &bfsResult{node: src, paths: []uint32{src}}
 	existTab := map[uint32]struct{}{src: {}}
+	for _, id := range exclude {
+		existTab[id] = struct{}{}
+	}
 	var subId uint32
 	for len(queue) > 0 {
 		current := queue[0]
@@ -441,6 +456,14 @@ func (p *RouterBFS) CalcRoute(dst uint32) (*router.RouteEmpty, bool) {
 	return empty, true
 }
 
+// ReachableVia 源节点src能否经由邻居节点via到达当前节点，即存在一条从via到src且不经过当前节点的路径
+func (p *RouterBFS) ReachableVia(src, via uint32) bool {
+	if src == p.node.NodeId() {
+		return false
+	}
+	return len(p.bfsSearch(via, src, p.node.RouteHop(), p.node.NodeId())) > 0
+}
+
 func (p *RouterBFS) StartNodeSync(ctx context.Context, timeout time.Duration) {
 	go func() {
 		ticker := time.NewTicker(timeout)
@@ -2,6 +2,8 @@ package routerbfs
 
 import (
 	"fmt"
+	"github.com/Li-giegie/node"
+	"github.com/Li-giegie/node/pkg/conn"
 	"testing"
 )
 
@@ -38,3 +40,27 @@ func checkAndReplace(num int64, arr []int64) bool {
 	arr[minIndex] = num
 	return true
 }
+
+func TestReachableVia(t *testing.T) {
+	p := NewRouterBFS(1, node.NewServerOption(1))
+	p.AddNode(1, 2, conn.TypeServer)
+	p.AddNode(1, 10, conn.TypeClient)
+	p.AddNode(2, 1, conn.TypeServer)
+	p.AddNode(2, 20, conn.TypeClient)
+	p.AddNode(2, 3, conn.TypeServer)
+	p.AddNode(3, 30, conn.TypeClient)
+	for _, tc := range []struct {
+		src, via uint32
+		want     bool
+	}{
+		{20, 2, true},
+		{30, 2, true},
+		{10, 2, false},
+		{1, 2, false},
+		{99, 2, false},
+	} {
+		if got := p.ReachableVia(tc.src, tc.via); got != tc.want {
+			t.Errorf("ReachableVia(%d, %d) = %v, want %v", tc.src, tc.via, got, tc.want)
+		}
+	}
+}
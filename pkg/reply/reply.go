@@ -1,6 +1,7 @@
 package reply
 
 import (
+	"context"
 	"encoding/json"
 	"github.com/Li-giegie/node/pkg/conn"
 	"github.com/Li-giegie/node/pkg/errors"
@@ -15,11 +16,20 @@ func NewReply(conn *conn.Conn, mId, dstId uint32) *Reply {
 	}
 }
 
+// NewReplyWithContext 创建携带上下文的回复，请求方取消请求、请求超时或连接断开时ctx被取消
+func NewReplyWithContext(ctx context.Context, conn *conn.Conn, mId, dstId uint32) *Reply {
+	r := NewReply(conn, mId, dstId)
+	r.ctx = ctx
+	return r
+}
+
 type Reply struct {
+	ctx      context.Context
 	conn     *conn.Conn
 	msgId    uint32
 	msgDstId uint32
 	response bool
+	md       message.Metadata
 }
 
 // Write 回复数据，type为 message.MsgType_Reply，限制回复一次，不要尝试多次回复，多次回复返回 var ErrLimitReply = errors.New("limit reply to one time")
@@ -32,15 +42,21 @@ func (c *Reply) Write(code int16, data []byte) error {
 	reData[0], reData[1] = byte(code), byte(code>>8)
 	copy(reData[2:], data)
 	return c.conn.SendMessage(&message.Message{
-		Type:   message.MsgType_Response,
-		Hop:    0,
-		Id:     c.msgId,
-		SrcId:  c.conn.LocalId(),
-		DestId: c.msgDstId,
-		Data:   reData,
+		Type:     message.MsgType_Response,
+		Hop:      0,
+		Id:       c.msgId,
+		SrcId:    c.conn.LocalId(),
+		DestId:   c.msgDstId,
+		Data:     reData,
+		Metadata: c.md,
 	})
 }
 
+// SetMetadata 设置响应的元数据，需在Write之前调用，相同的键覆盖之前设置的值
+func (c *Reply) SetMetadata(md message.Metadata) {
+	c.md = message.MergeMetadata(c.md, md)
+}
+
 func (c *Reply) String(code int16, data string) error {
 	return c.Write(code, []byte(data))
 }
@@ -56,3 +72,11 @@ func (c *Reply) JSON(code int16, data any) error {
 func (c *Reply) GetConn() *conn.Conn {
 	return c.conn
 }
+
+// Context 返回请求的上下文，未设置时返回 context.Background()
+func (c *Reply) Context() context.Context {
+	if c.ctx == nil {
+		return context.Background()
+	}
+	return c.ctx
+}
@@ -26,6 +26,10 @@ type Router interface {
 	ReroutingHandleFunc(f func(dst uint32) (*RouteEmpty, bool))
 	// Rerouting 重新计算目的路由下一跳，而不是从路由表中取路由
 	Rerouting(dst uint32) (*RouteEmpty, bool)
+	// ReachableHandleFunc 添加判断源节点能否经由邻居节点到达的处理方法，通常由动态路由协议添加
+	ReachableHandleFunc(f func(src, via uint32) bool)
+	// Reachable 源节点src能否经由邻居节点via到达当前节点，src为via、src的路由下一跳为via或任一处理方法返回true时可达
+	Reachable(src, via uint32) bool
 	RouteLen() int
 }
 
@@ -3,32 +3,124 @@ package server
 import (
 	"github.com/Li-giegie/node/pkg/conn"
 	"sync"
+	"time"
 )
 
 type connections struct {
 	m map[uint32]*conn.Conn
-	l sync.RWMutex
+	// 按连接类型统计的连接数
+	n map[conn.Type]int
+	// 连接的OnClose回调执行完毕时关闭，见 connDone
+	done map[*conn.Conn]chan struct{}
+	// 节点凭据被吊销的次数，见 revoke
+	revoked map[uint32]uint64
+	l       sync.RWMutex
 }
 
 func (s *connections) AddConn(c *conn.Conn) bool {
+	_, added, _ := s.addConnLimit(c, 0, 0, nil)
+	return added
+}
+
+// addConnLimit 同 AddConn，连接总数达到total或同类型的连接数达到typed时不添加并返回busy，小于等于0时不限制，
+// 节点Id已存在连接时replace返回true则由c替换并返回旧连接old，调用方负责关闭旧连接
+func (s *connections) addConnLimit(c *conn.Conn, total, typed int, replace func(old *conn.Conn) bool) (old *conn.Conn, added, busy bool) {
 	s.l.Lock()
 	defer s.l.Unlock()
 	if s.m == nil {
 		s.m = make(map[uint32]*conn.Conn)
+		s.n = make(map[conn.Type]int)
+		s.done = make(map[*conn.Conn]chan struct{})
+	}
+	n, typedN := len(s.m), s.n[c.ConnType()]
+	old, exist := s.m[c.RemoteId()]
+	if exist {
+		if replace == nil || !replace(old) {
+			return nil, false, false
+		}
+		n--
+		if old.ConnType() == c.ConnType() {
+			typedN--
+		}
 	}
-	_, exist := s.m[c.RemoteId()]
-	if !exist {
-		s.m[c.RemoteId()] = c
+	if total > 0 && n >= total || typed > 0 && typedN >= typed {
+		return nil, false, true
 	}
-	return !exist
+	if exist {
+		s.n[old.ConnType()]--
+	}
+	s.m[c.RemoteId()] = c
+	s.n[c.ConnType()]++
+	s.done[c] = make(chan struct{})
+	return old, true, false
+}
+
+// revoke 记录节点的凭据被吊销并返回与其的连接，与 revokeSeq 配合使认证期间被吊销的连接无法添加
+func (s *connections) revoke(id uint32) (*conn.Conn, bool) {
+	s.l.Lock()
+	defer s.l.Unlock()
+	if s.revoked == nil {
+		s.revoked = make(map[uint32]uint64)
+	}
+	s.revoked[id]++
+	c, ok := s.m[id]
+	return c, ok
+}
+
+// revokeSeq 返回节点凭据被吊销的次数，认证前后不一致时说明认证期间凭据被吊销
+func (s *connections) revokeSeq(id uint32) uint64 {
+	s.l.RLock()
+	defer s.l.RUnlock()
+	return s.revoked[id]
 }
 
 func (s *connections) RemoveConn(id uint32) {
 	s.l.Lock()
-	delete(s.m, id)
+	if c, ok := s.m[id]; ok {
+		delete(s.m, id)
+		s.n[c.ConnType()]--
+	}
 	s.l.Unlock()
 }
 
+// removeConn 移除连接，节点Id已被新连接接管时不移除
+func (s *connections) removeConn(c *conn.Conn) {
+	s.l.Lock()
+	if s.m[c.RemoteId()] == c {
+		delete(s.m, c.RemoteId())
+		s.n[c.ConnType()]--
+	}
+	s.l.Unlock()
+}
+
+// connDone 连接的清理及OnClose回调执行完毕
+func (s *connections) connDone(c *conn.Conn) {
+	s.l.Lock()
+	if ch, ok := s.done[c]; ok {
+		close(ch)
+		delete(s.done, c)
+	}
+	s.l.Unlock()
+}
+
+// waitConnDone 等待连接的OnClose回调执行完毕，超时返回false
+func (s *connections) waitConnDone(c *conn.Conn, timeout time.Duration) bool {
+	s.l.RLock()
+	ch, ok := s.done[c]
+	s.l.RUnlock()
+	if !ok {
+		return true
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-ch:
+		return true
+	case <-timer.C:
+		return false
+	}
+}
+
 func (s *connections) GetConn(id uint32) (*conn.Conn, bool) {
 	s.l.RLock()
 	v, ok := s.m[id]
@@ -62,3 +154,11 @@ func (s *connections) LenConn() (n int) {
 	s.l.RUnlock()
 	return
 }
+
+// LenConnWithType 类型为typ的连接数
+func (s *connections) LenConnWithType(typ conn.Type) (n int) {
+	s.l.RLock()
+	n = s.n[typ]
+	s.l.RUnlock()
+	return
+}
@@ -0,0 +1,40 @@
+package server
+
+import (
+	"github.com/Li-giegie/node/pkg/conn"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newTestConn(typ conn.Type, id uint32) *conn.Conn {
+	a, _ := net.Pipe()
+	var l sync.Mutex
+	var seq uint32
+	return conn.NewConn(typ, 1, id, a, nil, &l, &seq, 0, 0, 0, 0)
+}
+
+func TestConnectionsTakeover(t *testing.T) {
+	var s connections
+	old := newTestConn(conn.TypeClient, 10)
+	if _, added, _ := s.addConnLimit(old, 1, 0, nil); !added {
+		t.Fatal("not added")
+	}
+	c := newTestConn(conn.TypeClient, 10)
+	if _, added, busy := s.addConnLimit(c, 1, 0, nil); added || busy {
+		t.Fatal("duplicate added", added, busy)
+	}
+	replaced, added, _ := s.addConnLimit(c, 1, 0, func(*conn.Conn) bool { return true })
+	if !added || replaced != old || s.LenConn() != 1 || s.LenConnWithType(conn.TypeClient) != 1 {
+		t.Fatal("not replaced")
+	}
+	s.removeConn(old)
+	if got, ok := s.GetConn(10); !ok || got != c {
+		t.Fatal("new conn removed by old conn")
+	}
+	go s.connDone(old)
+	if !s.waitConnDone(old, time.Second) {
+		t.Fatal("wait done timeout")
+	}
+}
@@ -0,0 +1,135 @@
+package server
+
+import (
+	"github.com/Li-giegie/node/pkg/conn"
+	"net"
+	"net/netip"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// admit 在 OnAccept 之前按IP黑白名单、每IP握手速率、未认证连接数检查新连接，返回true时调用方在握手结束后调用 s.handshakeDone
+func (s *Server) admit(native net.Conn) bool {
+	if ip, ok := remoteIP(native); ok {
+		if !s.ipAllowed(ip) {
+			return false
+		}
+		if s.HandshakeRate > 0 && !s.limiter.allow(ip, s.HandshakeRate, s.HandshakeBurst) {
+			return false
+		}
+	}
+	if s.MaxPendingHandshakes > 0 && atomic.AddInt64(&s.pendingHandshakes, 1) > int64(s.MaxPendingHandshakes) {
+		atomic.AddInt64(&s.pendingHandshakes, -1)
+		return false
+	}
+	return true
+}
+
+func (s *Server) handshakeDone() {
+	if s.MaxPendingHandshakes > 0 {
+		atomic.AddInt64(&s.pendingHandshakes, -1)
+	}
+}
+
+// ipAllowed 命中 DenyNets 时拒绝，AllowNets 不为空时必须命中其中之一
+func (s *Server) ipAllowed(ip netip.Addr) bool {
+	for _, p := range s.DenyNets {
+		if p.Contains(ip) {
+			return false
+		}
+	}
+	if len(s.AllowNets) == 0 {
+		return true
+	}
+	for _, p := range s.AllowNets {
+		if p.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// remoteIP 返回连接对端的IP，非IP连接（例如unix）返回false
+func remoteIP(c net.Conn) (netip.Addr, bool) {
+	var ip net.IP
+	switch addr := c.RemoteAddr().(type) {
+	case *net.TCPAddr:
+		ip = addr.IP
+	case *net.UDPAddr:
+		ip = addr.IP
+	default:
+		return netip.Addr{}, false
+	}
+	a, ok := netip.AddrFromSlice(ip)
+	return a.Unmap(), ok
+}
+
+// ipLimiter 每IP一个令牌桶
+type ipLimiter struct {
+	l         sync.Mutex
+	buckets   map[netip.Addr]*bucket
+	lastSweep time.Time
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func (l *ipLimiter) allow(ip netip.Addr, rate float64, burst int) bool {
+	if burst < 1 {
+		burst = 1
+	}
+	now := time.Now()
+	l.l.Lock()
+	defer l.l.Unlock()
+	if l.buckets == nil {
+		l.buckets = make(map[netip.Addr]*bucket)
+	}
+	// 移除已经回满的桶，回满的桶与新建的桶等价
+	full := time.Duration(float64(burst) / rate * float64(time.Second))
+	if now.Sub(l.lastSweep) > full {
+		for addr, b := range l.buckets {
+			if now.Sub(b.last) > full {
+				delete(l.buckets, addr)
+			}
+		}
+		l.lastSweep = now
+	}
+	b, ok := l.buckets[ip]
+	if !ok {
+		b = &bucket{tokens: float64(burst), last: now}
+		l.buckets[ip] = b
+	}
+	b.tokens += now.Sub(b.last).Seconds() * rate
+	if b.tokens > float64(burst) {
+		b.tokens = float64(burst)
+	}
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// connLimit 返回typ类型连接的最大连接数，0为不限制
+func (s *Server) connLimit(typ conn.Type) int {
+	switch typ {
+	case conn.TypeClient:
+		return s.MaxClientConnections
+	case conn.TypeServer:
+		return s.MaxServerConnections
+	}
+	return 0
+}
+
+// busy 连接总数或typ类型的连接数已达到上限
+func (s *Server) busy(typ conn.Type) bool {
+	if s.MaxConnections > 0 && s.LenConn() >= s.MaxConnections {
+		return true
+	}
+	limit := s.connLimit(typ)
+	return limit > 0 && s.LenConnWithType(typ) >= limit
+}
@@ -0,0 +1,39 @@
+package server
+
+import (
+	"net/netip"
+	"testing"
+	"time"
+)
+
+func TestIPLimiter(t *testing.T) {
+	var l ipLimiter
+	a, b := netip.MustParseAddr("10.0.0.1"), netip.MustParseAddr("10.0.0.2")
+	for i := 0; i < 3; i++ {
+		if !l.allow(a, 10, 3) {
+			t.Fatal("burst denied", i)
+		}
+	}
+	if l.allow(a, 10, 3) {
+		t.Fatal("rate not limited")
+	}
+	if !l.allow(b, 10, 3) {
+		t.Fatal("other ip limited")
+	}
+	time.Sleep(120 * time.Millisecond)
+	if !l.allow(a, 10, 3) {
+		t.Fatal("tokens not refilled")
+	}
+}
+
+func TestIPAllowed(t *testing.T) {
+	s := &Server{
+		AllowNets: []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")},
+		DenyNets:  []netip.Prefix{netip.MustParsePrefix("10.1.0.0/16")},
+	}
+	for ip, want := range map[string]bool{"10.0.0.1": true, "10.1.0.1": false, "192.168.0.1": false} {
+		if got := s.ipAllowed(netip.MustParseAddr(ip)); got != want {
+			t.Errorf("ipAllowed(%s) = %v, want %v", ip, got, want)
+		}
+	}
+}
@@ -3,8 +3,11 @@ package server
 import (
 	"github.com/Li-giegie/node/pkg/conn"
 	"github.com/Li-giegie/node/pkg/message"
+	"github.com/Li-giegie/node/pkg/middleware"
 	"github.com/Li-giegie/node/pkg/reply"
+	"github.com/Li-giegie/node/pkg/stream"
 	"net"
+	"sync"
 )
 
 type Handler interface {
@@ -18,6 +21,12 @@ type Handler interface {
 	OnClose(conn *conn.Conn, err error)
 }
 
+// StreamHandler 可选接口，Handler实现该接口时接受对端发起的流，否则拒绝
+type StreamHandler interface {
+	// OnStream 对端发起流时的回调函数，在新的goroutine中调用，返回后流被关闭
+	OnStream(s *stream.Stream)
+}
+
 var Default Manager
 
 type (
@@ -25,22 +34,34 @@ type (
 	OnConnectFunc func(conn *conn.Conn) (next bool)
 	OnMessageFunc func(r *reply.Reply, m *message.Message) (next bool)
 	OnCloseFunc   func(conn *conn.Conn, err error) (next bool)
+	OnStreamFunc  func(s *stream.Stream)
 )
 
-// Manager 处理器管理器
+// Manager 处理器管理器，运行期间可以并发的添加、替换、移除处理器
 type Manager struct {
 	onAcceptFunc  []OnAcceptFunc
 	onConnectFunc []OnConnectFunc
 	onCloseFunc   []OnCloseFunc
+	onStreamFunc  OnStreamFunc
 	handlers      map[uint8][]OnMessageFunc
+	middlewares   []middleware.Middleware
+	// 中间件包装后的处理函数，在Use时构建
+	handle middleware.HandlerFunc
+	// 当前管理器未注册的回调交由fallback处理
+	fallback *Manager
+	l        sync.RWMutex
 }
 
 func (m *Manager) AddOnAccept(fn ...OnAcceptFunc) {
+	m.l.Lock()
 	m.onAcceptFunc = append(m.onAcceptFunc, fn...)
+	m.l.Unlock()
 }
 
 func (m *Manager) AddOnConnect(fn ...OnConnectFunc) {
+	m.l.Lock()
 	m.onConnectFunc = append(m.onConnectFunc, fn...)
+	m.l.Unlock()
 }
 
 func (m *Manager) AddOnMessage(fn ...OnMessageFunc) {
@@ -50,18 +71,58 @@ func (m *Manager) AddOnMessage(fn ...OnMessageFunc) {
 }
 
 func (m *Manager) AddOnMessageWithType(typ uint8, fn OnMessageFunc) {
+	m.l.Lock()
+	defer m.l.Unlock()
 	if m.handlers == nil {
 		m.handlers = make(map[uint8][]OnMessageFunc)
 	}
 	m.handlers[typ] = append(m.handlers[typ], fn)
 }
 
+// SetOnMessageWithType 替换typ类型消息的全部处理器
+func (m *Manager) SetOnMessageWithType(typ uint8, fn ...OnMessageFunc) {
+	m.l.Lock()
+	defer m.l.Unlock()
+	if m.handlers == nil {
+		m.handlers = make(map[uint8][]OnMessageFunc)
+	}
+	m.handlers[typ] = append([]OnMessageFunc(nil), fn...)
+}
+
+// RemoveOnMessageWithType 移除typ类型消息的全部处理器，返回是否存在，移除后该类型的消息回复 message.StateCode_MessageTypeInvalid，不再交由fallback处理
+func (m *Manager) RemoveOnMessageWithType(typ uint8) bool {
+	m.l.Lock()
+	defer m.l.Unlock()
+	if m.handlers == nil {
+		m.handlers = make(map[uint8][]OnMessageFunc)
+	}
+	h := m.handlers[typ]
+	// 保留值为nil的记录作为移除标记
+	m.handlers[typ] = nil
+	return len(h) > 0
+}
+
 func (m *Manager) AddOnClose(fn ...OnCloseFunc) {
+	m.l.Lock()
 	m.onCloseFunc = append(m.onCloseFunc, fn...)
+	m.l.Unlock()
+}
+
+// SetOnStream 设置流处理器，fn为nil时移除
+func (m *Manager) SetOnStream(fn OnStreamFunc) {
+	m.l.Lock()
+	m.onStreamFunc = fn
+	m.l.Unlock()
 }
 
 func (m *Manager) OnAccept(c net.Conn) bool {
-	for _, fn := range m.onAcceptFunc {
+	m.l.RLock()
+	fns := m.onAcceptFunc
+	m.l.RUnlock()
+	if len(fns) == 0 && m.fallback != nil {
+		return m.fallback.OnAccept(c)
+	}
+	for _, fn := range fns {
 		if !fn(c) {
 			return false
 		}
@@ -70,32 +131,98 @@ func (m *Manager) OnAccept(c net.Conn) bool {
 }
 
 func (m *Manager) OnConnect(c *conn.Conn) {
-	for _, fn := range m.onConnectFunc {
+	m.l.RLock()
+	fns := m.onConnectFunc
+	m.l.RUnlock()
+	if len(fns) == 0 && m.fallback != nil {
+		m.fallback.OnConnect(c)
+		return
+	}
+	for _, fn := range fns {
 		if !fn(c) {
 			return
 		}
 	}
 }
 
+// Use 添加OnMessage中间件，中间件作用于所有类型的消息，先添加的位于外层
+func (m *Manager) Use(mw ...middleware.Middleware) {
+	m.l.Lock()
+	m.middlewares = append(m.middlewares, mw...)
+	m.handle = middleware.Chain(m.middlewares...)(func(w *reply.Reply, msg *message.Message) bool {
+		m.dispatch(w, msg, false)
+		return true
+	})
+	m.l.Unlock()
+}
+
+// OnMessage 当前管理器添加了中间件时，交由fallback处理的消息只经过当前管理器的中间件，否则经过fallback的中间件
 func (m *Manager) OnMessage(w *reply.Reply, msg *message.Message) {
-	h := m.handlers[msg.Type]
-	if h != nil {
+	m.l.RLock()
+	handle := m.handle
+	m.l.RUnlock()
+	if handle != nil {
+		handle(w, msg)
+		return
+	}
+	m.dispatch(w, msg, true)
+}
+
+// dispatch 调用消息类型对应的处理器，当前管理器未注册时交由fallback处理，wrap为true时经过fallback的中间件
+func (m *Manager) dispatch(w *reply.Reply, msg *message.Message, wrap bool) {
+	m.l.RLock()
+	h, ok := m.handlers[msg.Type]
+	m.l.RUnlock()
+	switch {
+	case len(h) > 0:
 		for _, fn := range h {
 			fn(w, msg)
 		}
-	} else {
+	case !ok && m.fallback != nil:
+		if wrap {
+			m.fallback.OnMessage(w, msg)
+		} else {
+			m.fallback.dispatch(w, msg, false)
+		}
+	default:
 		w.Write(message.StateCode_MessageTypeInvalid, nil)
 	}
 }
 
 func (m *Manager) OnClose(c *conn.Conn, err error) {
-	for _, fn := range m.onCloseFunc {
+	m.l.RLock()
+	fns := m.onCloseFunc
+	m.l.RUnlock()
+	if len(fns) == 0 && m.fallback != nil {
+		m.fallback.OnClose(c, err)
+		return
+	}
+	for _, fn := range fns {
 		if !fn(c, err) {
 			return
 		}
 	}
 }
 
+func (m *Manager) OnStream(s *stream.Stream) {
+	if fn := m.streamHandler(); fn != nil {
+		fn(s)
+		return
+	}
+	_ = s.Reset()
+}
+
+// streamHandler 返回当前管理器或fallback上的流处理器，均未设置时返回nil
+func (m *Manager) streamHandler() OnStreamFunc {
+	m.l.RLock()
+	fn := m.onStreamFunc
+	m.l.RUnlock()
+	if fn == nil && m.fallback != nil {
+		return m.fallback.streamHandler()
+	}
+	return fn
+}
+
 func OnAccept(fn ...OnAcceptFunc) {
 	Default.AddOnAccept(fn...)
 }
@@ -108,6 +235,12 @@ func OnMessage(fn ...OnMessageFunc) {
 func OnMessageType(typ uint8, fn OnMessageFunc) {
 	Default.AddOnMessageWithType(typ, fn)
 }
+func Use(mw ...middleware.Middleware) {
+	Default.Use(mw...)
+}
 func OnClose(fn ...OnCloseFunc) {
 	Default.AddOnClose(fn...)
 }
+func OnStream(fn OnStreamFunc) {
+	Default.SetOnStream(fn)
+}
@@ -0,0 +1,73 @@
+package server
+
+import (
+	"github.com/Li-giegie/node/pkg/errors"
+	"sync"
+)
+
+// IdAllocator 为节点Id为0的客户端分配节点Id，桥接的各服务端应使用互不重叠的范围以保证节点Id在域内唯一
+type IdAllocator interface {
+	// Allocate 分配一个节点Id，inUse返回true的节点Id已被当前节点的连接或路由使用，不能分配
+	Allocate(inUse func(id uint32) bool) (uint32, error)
+	// Release 分配的节点Id的连接关闭后回收，未分配的节点Id忽略
+	Release(id uint32)
+}
+
+// NewRangeAllocator 从[min, max]中依次分配节点Id，min为0时从1开始
+func NewRangeAllocator(min, max uint32) IdAllocator {
+	if min == 0 {
+		min = 1
+	}
+	return &rangeAllocator{min: min, max: max, next: min, used: make(map[uint32]struct{})}
+}
+
+type rangeAllocator struct {
+	l        sync.Mutex
+	min, max uint32
+	next     uint32
+	used     map[uint32]struct{}
+}
+
+func (a *rangeAllocator) Allocate(inUse func(id uint32) bool) (uint32, error) {
+	a.l.Lock()
+	defer a.l.Unlock()
+	if a.max < a.min {
+		return 0, errors.ErrIdExhausted
+	}
+	// 从上次分配的位置继续，避免刚回收的节点Id被立即复用
+	for n := uint64(a.max-a.min) + 1; n > 0; n-- {
+		id := a.next
+		if a.next == a.max {
+			a.next = a.min
+		} else {
+			a.next++
+		}
+		if _, ok := a.used[id]; ok || inUse(id) {
+			continue
+		}
+		a.used[id] = struct{}{}
+		return id, nil
+	}
+	return 0, errors.ErrIdExhausted
+}
+
+func (a *rangeAllocator) Release(id uint32) {
+	a.l.Lock()
+	delete(a.used, id)
+	a.l.Unlock()
+}
+
+// idInUse 节点Id是当前节点、已连接或路由可达的节点
+func (s *Server) idInUse(id uint32) bool {
+	if id == s.Id {
+		return true
+	}
+	if _, ok := s.GetConn(id); ok {
+		return true
+	}
+	if _, ok := s.GetRoute(id); ok {
+		return true
+	}
+	_, ok := s.Rerouting(id)
+	return ok
+}
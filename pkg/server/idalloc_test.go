@@ -0,0 +1,21 @@
+package server
+
+import "testing"
+
+func TestRangeAllocator(t *testing.T) {
+	a := NewRangeAllocator(10, 12)
+	inUse := func(id uint32) bool { return id == 11 }
+	if id, err := a.Allocate(inUse); err != nil || id != 10 {
+		t.Fatal(id, err)
+	}
+	if id, err := a.Allocate(inUse); err != nil || id != 12 {
+		t.Fatal(id, err)
+	}
+	if _, err := a.Allocate(inUse); err == nil {
+		t.Fatal("expected exhausted")
+	}
+	a.Release(10)
+	if id, err := a.Allocate(inUse); err != nil || id != 10 {
+		t.Fatal(id, err)
+	}
+}
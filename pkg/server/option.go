@@ -1,6 +1,15 @@
 package server
 
-import "time"
+import (
+	"github.com/Li-giegie/node/pkg/acl"
+	"github.com/Li-giegie/node/pkg/auth"
+	"github.com/Li-giegie/node/pkg/compress"
+	"github.com/Li-giegie/node/pkg/conn"
+	"github.com/Li-giegie/node/pkg/dispatch"
+	"github.com/Li-giegie/node/pkg/message"
+	"net/netip"
+	"time"
+)
 
 func DefaultConfig(opts ...Option) *Config {
 	c := &Config{
@@ -13,6 +22,9 @@ func DefaultConfig(opts ...Option) *Config {
 		KeepaliveInterval:     time.Second * 20,
 		KeepaliveTimeout:      time.Second * 40,
 		KeepaliveTimeoutClose: time.Second * 120,
+		DispatchQueueSize:     1024,
+		Integrity:             conn.IntegrityCRC32C,
+		SrcIdCheck:            SrcIdCheckClient,
 	}
 	for _, opt := range opts {
 		opt(c)
@@ -37,16 +49,78 @@ type Config struct {
 	ReaderBufSize int
 	// 大于64时启用，从队列读取后进入缓冲区，缓冲区大小
 	WriterBufSize int
-	// 大于0启用，最大连接数
+	// 大于0启用，最大连接数，达到时新连接的握手回复服务端繁忙
 	MaxConnections int
-	// 超过最大连接休眠时长，MaxConns>0时有效
+	// 大于0启用，客户端类型连接的最大连接数
+	MaxClientConnections int
+	// 大于0启用，服务端类型（桥接）连接的最大连接数
+	MaxServerConnections int
+	// 服务端繁忙时建议对端的重试间隔
+	BusyRetryAfter time.Duration
+	// Deprecated: 达到最大连接数时不再休眠
 	SleepOnMaxConnections time.Duration
+	// 节点Id已存在连接时新连接的处理策略，默认 TakeoverReject
+	Takeover TakeoverPolicy
+	// Takeover为 TakeoverReplaceIdle 时旧连接的空闲时长，0时使用 KeepaliveTimeout
+	TakeoverIdle time.Duration
+	// 不为nil时为节点Id为0的客户端分配节点Id
+	IdAllocator IdAllocator
 	// 连接保活检查时间间隔 > 0启用
 	KeepaliveInterval time.Duration
 	// 连接保活超时时间 > 0启用
 	KeepaliveTimeout time.Duration
 	// 连接保活最大超时次数
 	KeepaliveTimeoutClose time.Duration
+	// 消息分发模式，默认 dispatch.ModeInline 在读循环中同步调用OnMessage
+	DispatchMode dispatch.Mode
+	// DispatchMode为 dispatch.ModePool 时的工作协程数，小于等于0时为CPU核数
+	DispatchWorkers int
+	// DispatchMode为 dispatch.ModePool 时的任务队列长度
+	DispatchQueueSize int
+	// DispatchMode为 dispatch.ModePool 时，同一个源节点的消息按接收顺序处理
+	DispatchOrdered bool
+	// 用户回调（OnAccept、OnConnect、OnMessage、OnClose）发生panic时的回调，c、m可能为nil，为nil时输出到标准日志
+	PanicHandler func(c *conn.Conn, m *message.Message, v any, stack []byte)
+	// 用户回调发生panic后是否关闭该连接，默认保持连接
+	CloseOnPanic bool
+	// 每个流的接收窗口大小，0时使用 stream.DefaultWindow
+	StreamWindow uint32
+	// 流的空闲超时，超过该时间未收到对端的任何帧时终止流，0时使用 stream.DefaultIdleTimeout，小于0时不检查
+	StreamIdleTimeout time.Duration
+	// 期望的消息完整性校验方式，与对端协商后取较强的一种，v1握手时总是 conn.IntegrityLegacy，默认 conn.IntegrityCRC32C
+	Integrity conn.Integrity
+	// 握手版本，0为当前支持的最高版本，对端为旧版本节点时设置为1
+	HandshakeVersion uint8
+	// 握手时向对端声明支持的协议消息类型
+	Protocols []uint8
+	// 发送消息使用的压缩算法，nil不压缩
+	Compressor compress.Compressor
+	// Data长度不小于该值时压缩，0时使用 compress.DefaultThreshold
+	CompressThreshold int
+	// 接受静态密钥摘要认证，仅用于兼容旧版本节点
+	LegacyAuth bool
+	// 认证对端，nil时使用 auth.Key(AuthKey)
+	Authenticator auth.Authenticator
+	// 握手时发送给对端的自定义凭据
+	Token []byte
+	// 不为nil时将对端的TLS证书与其声明的节点Id绑定
+	CertBinding auth.CertBinding
+	// 接收消息时的源节点Id校验模式，默认 SrcIdCheckClient
+	SrcIdCheck SrcIdCheck
+	// 源节点Id校验失败时关闭连接
+	CloseOnSrcIdViolation bool
+	// 大于0时启用，正在握手的未认证连接的最大数量
+	MaxPendingHandshakes int
+	// 大于0时启用，每个IP每秒允许发起的握手次数
+	HandshakeRate float64
+	// 每个IP允许突发的握手次数
+	HandshakeBurst int
+	// 拒绝的对端IP网段
+	DenyNets []netip.Prefix
+	// 不为空时只接受这些网段的对端IP
+	AllowNets []netip.Prefix
+	// 不为nil时检查每条消息的源节点能否向目的节点发送该类型的消息，例如 acl.NewRules、acl.NewFileRules
+	ACL acl.Policy
 }
 
 type Option func(*Config)
@@ -99,6 +173,23 @@ func WithMaxConnections(max int) Option {
 		c.MaxConnections = max
 	}
 }
+
+// WithConnectionLimits 分别限制客户端类型和服务端类型连接的最大连接数，避免桥接节点因客户端连接过多而无法接入，小于等于0时不限制
+func WithConnectionLimits(clients, servers int) Option {
+	return func(c *Config) {
+		c.MaxClientConnections = clients
+		c.MaxServerConnections = servers
+	}
+}
+
+// WithBusyRetryAfter 设置服务端繁忙时建议对端的重试间隔
+func WithBusyRetryAfter(d time.Duration) Option {
+	return func(c *Config) {
+		c.BusyRetryAfter = d
+	}
+}
+
+// Deprecated: 达到最大连接数时不再休眠，新连接的握手回复服务端繁忙
 func WithSleepOnMaxConnections(sleepOnMaxConnections time.Duration) Option {
 	return func(c *Config) {
 		c.SleepOnMaxConnections = sleepOnMaxConnections
@@ -119,3 +210,146 @@ func WithKeepaliveTimeoutClose(timeout time.Duration) Option {
 		c.KeepaliveTimeoutClose = timeout
 	}
 }
+
+// WithDispatch 设置消息分发模式，workers、queueSize、ordered仅在 dispatch.ModePool 时有效
+func WithDispatch(mode dispatch.Mode, workers, queueSize int, ordered bool) Option {
+	return func(c *Config) {
+		c.DispatchMode = mode
+		c.DispatchWorkers = workers
+		c.DispatchQueueSize = queueSize
+		c.DispatchOrdered = ordered
+	}
+}
+
+// WithPanicHandler 设置用户回调发生panic时的回调，closeConn为true时关闭发生panic的连接
+func WithPanicHandler(f func(c *conn.Conn, m *message.Message, v any, stack []byte), closeConn bool) Option {
+	return func(c *Config) {
+		c.PanicHandler = f
+		c.CloseOnPanic = closeConn
+	}
+}
+
+// WithStreamWindow 设置每个流的接收窗口大小
+func WithStreamWindow(n uint32) Option {
+	return func(c *Config) {
+		c.StreamWindow = n
+	}
+}
+
+// WithStreamIdleTimeout 设置流的空闲超时，小于0时不检查
+func WithStreamIdleTimeout(d time.Duration) Option {
+	return func(c *Config) {
+		c.StreamIdleTimeout = d
+	}
+}
+
+// WithIntegrity 设置期望的消息完整性校验方式，conn.IntegrityLegacy 仅校验消息头
+func WithIntegrity(i conn.Integrity) Option {
+	return func(c *Config) {
+		c.Integrity = i
+	}
+}
+
+// WithHandshakeVersion 设置握手版本，与旧版本节点建立连接时设置为1
+func WithHandshakeVersion(v uint8) Option {
+	return func(c *Config) {
+		c.HandshakeVersion = v
+	}
+}
+
+// WithProtocols 设置握手时向对端声明支持的协议消息类型
+func WithProtocols(typ ...uint8) Option {
+	return func(c *Config) {
+		c.Protocols = typ
+	}
+}
+
+// WithCompression 设置发送消息使用的压缩算法，Data长度不小于threshold时压缩，threshold为0时使用 compress.DefaultThreshold
+func WithCompression(cp compress.Compressor, threshold int) Option {
+	return func(c *Config) {
+		c.Compressor = cp
+		c.CompressThreshold = threshold
+	}
+}
+
+// WithLegacyAuth 设置是否接受静态密钥摘要认证，静态密钥摘要可被截获重放
+func WithLegacyAuth(enable bool) Option {
+	return func(c *Config) {
+		c.LegacyAuth = enable
+	}
+}
+
+// WithAuthenticator 设置认证对端的 auth.Authenticator
+func WithAuthenticator(a auth.Authenticator) Option {
+	return func(c *Config) {
+		c.Authenticator = a
+	}
+}
+
+// WithToken 设置握手时发送给对端的自定义凭据
+func WithToken(token []byte) Option {
+	return func(c *Config) {
+		c.Token = token
+	}
+}
+
+// WithCertBinding 将对端的mTLS证书与其声明的节点Id绑定，例如 auth.CertURIBinding("node")，需要 tls.Config 要求并验证客户端证书
+func WithCertBinding(b auth.CertBinding) Option {
+	return func(c *Config) {
+		c.CertBinding = b
+	}
+}
+
+// WithSrcIdCheck 设置接收消息时的源节点Id校验模式，closeConn为true时关闭发送伪造源节点Id消息的连接
+func WithSrcIdCheck(mode SrcIdCheck, closeConn bool) Option {
+	return func(c *Config) {
+		c.SrcIdCheck = mode
+		c.CloseOnSrcIdViolation = closeConn
+	}
+}
+
+// WithACL 设置消息级访问控制策略，拒绝的请求回复 message.StateCode_Forbidden，拒绝的单向消息直接丢弃，响应按请求的方向检查。
+// 启用路由协议时需要使用 WithProtocols 声明协议消息类型，否则默认拒绝的策略会丢弃邻居节点的路由协议消息
+func WithACL(p acl.Policy) Option {
+	return func(c *Config) {
+		c.ACL = p
+	}
+}
+
+// WithMaxPendingHandshakes 设置正在握手的未认证连接的最大数量
+func WithMaxPendingHandshakes(n int) Option {
+	return func(c *Config) {
+		c.MaxPendingHandshakes = n
+	}
+}
+
+// WithHandshakeRateLimit 限制每个IP每秒发起的握手次数，burst为允许突发的次数
+func WithHandshakeRateLimit(rate float64, burst int) Option {
+	return func(c *Config) {
+		c.HandshakeRate = rate
+		c.HandshakeBurst = burst
+	}
+}
+
+// WithIPFilter 设置对端IP黑白名单，例如 netip.MustParsePrefix("10.0.0.0/8")，deny优先，allow为空时接受deny之外的全部IP
+func WithIPFilter(allow, deny []netip.Prefix) Option {
+	return func(c *Config) {
+		c.AllowNets = allow
+		c.DenyNets = deny
+	}
+}
+
+// WithTakeover 设置节点Id已存在连接时新连接的处理策略，idle仅在 TakeoverReplaceIdle 时有效
+func WithTakeover(policy TakeoverPolicy, idle time.Duration) Option {
+	return func(c *Config) {
+		c.Takeover = policy
+		c.TakeoverIdle = idle
+	}
+}
+
+// WithIdAllocator 为节点Id为0的客户端分配节点Id，例如 NewRangeAllocator(10000, 19999)，桥接的各服务端应使用互不重叠的范围
+func WithIdAllocator(a IdAllocator) Option {
+	return func(c *Config) {
+		c.IdAllocator = a
+	}
+}
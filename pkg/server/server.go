@@ -2,15 +2,25 @@ package server
 
 import (
 	"context"
+	"crypto/hmac"
 	"crypto/tls"
 	"github.com/Li-giegie/node/internal"
 	"github.com/Li-giegie/node/internal/routemanager"
+	"github.com/Li-giegie/node/pkg/acl"
+	"github.com/Li-giegie/node/pkg/auth"
+	"github.com/Li-giegie/node/pkg/compress"
 	"github.com/Li-giegie/node/pkg/conn"
+	"github.com/Li-giegie/node/pkg/dispatch"
 	"github.com/Li-giegie/node/pkg/errors"
 	"github.com/Li-giegie/node/pkg/message"
+	"github.com/Li-giegie/node/pkg/middleware"
 	"github.com/Li-giegie/node/pkg/reply"
 	"github.com/Li-giegie/node/pkg/router"
+	"github.com/Li-giegie/node/pkg/stream"
+	"log"
 	"net"
+	"net/netip"
+	"runtime/debug"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -31,10 +41,22 @@ type Server struct {
 	ReaderBufSize int
 	// 大于64时启用，从队列读取后进入缓冲区，缓冲区大小
 	WriterBufSize int
-	// 大于0启用，最大连接数
+	// 大于0启用，最大连接数，达到时新连接的握手回复服务端繁忙，Bridge 发起的连接不受限制
 	MaxConnections int
-	// 超过最大连接休眠时长，MaxConns>0时有效
+	// 大于0启用，客户端类型连接的最大连接数
+	MaxClientConnections int
+	// 大于0启用，服务端类型（桥接）连接的最大连接数
+	MaxServerConnections int
+	// 服务端繁忙时建议对端的重试间隔，0为不指定
+	BusyRetryAfter time.Duration
+	// Deprecated: 达到最大连接数时不再休眠，新连接的握手回复服务端繁忙
 	SleepOnMaxConnections time.Duration
+	// 节点Id已存在连接时新连接的处理策略，默认 TakeoverReject，接管时旧连接以 errors.ErrConnReplaced 关闭
+	Takeover TakeoverPolicy
+	// Takeover为 TakeoverReplaceIdle 时旧连接的空闲时长，0时使用 KeepaliveTimeout
+	TakeoverIdle time.Duration
+	// 不为nil时为节点Id为0的客户端（v2握手）分配节点Id，例如 NewRangeAllocator，为nil时0作为普通的节点Id
+	IdAllocator IdAllocator
 	// 连接保活检查时间间隔 > 0启用
 	KeepaliveInterval time.Duration
 	// 连接保活超时时间 > 0启用
@@ -43,18 +65,78 @@ type Server struct {
 	KeepaliveTimeoutClose time.Duration
 	// 最大路由转发跳数
 	MaxRouteHop uint8
+	// 消息分发模式，默认 dispatch.ModeInline 在读循环中同步调用OnMessage
+	DispatchMode dispatch.Mode
+	// DispatchMode为 dispatch.ModePool 时的工作协程数，小于等于0时为CPU核数
+	DispatchWorkers int
+	// DispatchMode为 dispatch.ModePool 时的任务队列长度
+	DispatchQueueSize int
+	// DispatchMode为 dispatch.ModePool 时，同一个源节点的消息按接收顺序处理
+	DispatchOrdered bool
+	// 用户回调（OnAccept、OnConnect、OnMessage、OnClose）发生panic时的回调，c、m可能为nil，为nil时输出到标准日志
+	PanicHandler func(c *conn.Conn, m *message.Message, v any, stack []byte)
+	// 用户回调发生panic后是否关闭该连接，默认保持连接
+	CloseOnPanic bool
+	// 每个流的接收窗口大小，0时使用 stream.DefaultWindow
+	StreamWindow uint32
+	// 流的空闲超时，超过该时间未收到对端的任何帧时终止流，0时使用 stream.DefaultIdleTimeout，小于0时不检查
+	StreamIdleTimeout time.Duration
+	// 期望的消息完整性校验方式，与对端协商后取较强的一种
+	Integrity conn.Integrity
+	// Bridge 时使用的握手版本，0为当前支持的最高版本，对端为旧版本节点时设置为1
+	HandshakeVersion uint8
+	// 握手时向对端声明支持的协议消息类型
+	Protocols []uint8
+	// 认证对端，nil时使用 auth.Key(AuthKey)，Bridge时未指定对端密钥则使用其 auth.KeyAuthenticator 提供的密钥
+	Authenticator auth.Authenticator
+	// 握手时发送给对端的自定义凭据，不能超过 internal.HandshakeTokenMaxLen
+	Token []byte
+	// 不为nil时对端必须提供TLS证书，且证书允许使用其声明的节点Id，Bridge时校验对端证书允许使用remoteId
+	CertBinding auth.CertBinding
+	// 接受静态密钥摘要认证（v1握手或未使用挑战应答的请求），密钥摘要可被截获重放，仅用于兼容旧版本节点
+	LegacyAuth bool
+	// 发送消息使用的压缩算法，nil不压缩，对端不支持该算法时不压缩
+	Compressor compress.Compressor
+	// Data长度不小于该值时压缩，0时使用 compress.DefaultThreshold
+	CompressThreshold int
+	// 接收消息时的源节点Id校验模式，DefaultConfig 中为 SrcIdCheckClient，零值为 SrcIdCheckOff
+	SrcIdCheck SrcIdCheck
+	// 源节点Id校验失败时关闭连接
+	CloseOnSrcIdViolation bool
+	// 大于0时启用，正在握手的未认证连接的最大数量，超过时直接关闭新连接
+	MaxPendingHandshakes int
+	// 大于0时启用，每个IP每秒允许发起的握手次数，超过时直接关闭新连接
+	HandshakeRate float64
+	// 每个IP允许突发的握手次数，HandshakeRate大于0时有效，小于1时为1
+	HandshakeBurst int
+	// 对端IP命中其中之一时直接关闭连接，在 OnAccept 之前检查
+	DenyNets []netip.Prefix
+	// 不为空时对端IP必须命中其中之一，否则直接关闭连接，在 OnAccept 之前检查
+	AllowNets []netip.Prefix
+	// 不为nil时检查每条消息的源节点能否向目的节点发送该类型的消息，本地处理和转发前均检查，拒绝时回复 message.StateCode_Forbidden，见 WithACL
+	ACL acl.Policy
 	internalField
 }
 
 type internalField struct {
-	hashKey   []byte
-	idCounter uint32
-	state     uint32
-	recvChan  map[uint32]chan *message.Message
-	recvLock  sync.Mutex
+	authenticator auth.Authenticator
+	idCounter     uint32
+	state         uint32
+	handling      int64
+	// 源节点Id校验失败的消息数
+	srcIdViolations uint64
+	// 正在握手的连接数
+	pendingHandshakes int64
+	limiter           ipLimiter
+	recvChan          map[uint32]chan *message.Message
+	recvLock          sync.Mutex
+	dispatcher        *dispatch.Dispatcher
+	inflight          internal.Inflight
+	streams           *stream.Manager
 	net.Listener
 	routemanager.Router
 	connections
+	manager Manager
 	Handler
 }
 
@@ -75,41 +157,52 @@ func (s *Server) ListenAndServe(address string, h Handler, config ...*tls.Config
 	return s.Serve(listen, h)
 }
 
+// Serve 开启服务，h为nil时使用实例上注册的处理器，实例上未注册的回调使用全局 Default
 func (s *Server) Serve(l net.Listener, h Handler) error {
 	if h == nil {
-		s.Handler = &Default
+		s.manager.fallback = &Default
+		s.Handler = &s.manager
 	} else {
 		s.Handler = h
 	}
-	s.state = 1
 	s.Listener = l
+	atomic.StoreUint32(&s.state, 1)
 	s.recvChan = make(map[uint32]chan *message.Message)
-	s.hashKey = internal.Hash(s.AuthKey)
+	s.authenticator = s.Authenticator
+	if s.authenticator == nil {
+		s.authenticator = auth.Key(s.AuthKey)
+	}
+	s.dispatcher = dispatch.New(s.DispatchMode, s.DispatchWorkers, s.DispatchQueueSize, s.DispatchOrdered)
+	s.streams = stream.NewManager(s.StreamWindow, s.StreamIdleTimeout)
 	ctx, cancel := context.WithCancel(context.TODO())
 	s.StartKeepalive(ctx)
 	defer func() {
-		s.state = 0
+		atomic.StoreUint32(&s.state, 0)
 		cancel()
 		l.Close()
+		s.dispatcher.Close()
 	}()
 	for {
-		if s.MaxConnections > 0 && s.LenConn() > s.MaxConnections {
-			time.Sleep(s.SleepOnMaxConnections)
-			continue
-		}
 		native, err := l.Accept()
 		if err != nil {
-			if s.state == 1 {
+			if atomic.LoadUint32(&s.state) == 1 {
 				return err
 			}
 			return nil
 		}
+		if !s.admit(native) {
+			_ = native.Close()
+			continue
+		}
 		go func() {
-			if !s.OnAccept(native) {
+			var accept bool
+			if s.protect(nil, nil, nil, func() { accept = s.OnAccept(native) }) || !accept {
+				s.handshakeDone()
 				_ = native.Close()
 				return
 			}
 			c, success := s.Auth(native)
+			s.handshakeDone()
 			if !success {
 				return
 			}
@@ -120,6 +213,13 @@ func (s *Server) Serve(l net.Listener, h Handler) error {
 
 func (s *Server) Auth(native net.Conn) (c *conn.Conn, success bool) {
 	var code internal.BaseAuthResponseCode
+	var integrity conn.Integrity
+	var version uint8
+	var reason string
+	var retryAfter time.Duration
+	var assignedId uint32
+	// 限制握手期间的写入，防止对端不读取时阻塞
+	_ = native.SetWriteDeadline(time.Now().Add(s.AuthTimeout))
 	defer func() {
 		if code == 0 {
 			return
@@ -130,20 +230,41 @@ func (s *Server) Auth(native net.Conn) (c *conn.Conn, success bool) {
 			MaxMsgLen:             s.MaxMsgLen,
 			KeepaliveTimeout:      s.KeepaliveTimeout,
 			KeepaliveTimeoutClose: s.KeepaliveTimeoutClose,
+			Integrity:             integrity,
+			Version:               version,
+			Features:              conn.Features,
+			Protocols:             s.Protocols,
+			Codecs:                compress.Codecs(),
+			Token:                 s.Token,
+			Reason:                reason,
+			RetryAfter:            retryAfter,
+			AssignedId:            assignedId,
 		})
 		if code != internal.BaseAuthResponseCodeSuccess || err != nil {
 			if code == internal.BaseAuthResponseCodeSuccess {
-				s.RemoveConn(c.RemoteId())
+				s.removeConn(c)
+				s.connDone(c)
+			}
+			if assignedId != 0 {
+				s.IdAllocator.Release(assignedId)
 			}
 			_ = native.Close()
 			success = false
 			c = nil
+			return
 		}
+		_ = native.SetWriteDeadline(time.Time{})
 	}()
 	req, err := internal.DefaultAuthService.ReadRequest(native, s.AuthTimeout)
 	if err != nil {
 		return nil, false
 	}
+	version = internal.NegotiateVersion(req.Version)
+	if internal.MetadataLen(req.Metadata) > internal.HandshakeMetadataMaxLen || len(req.Token) > internal.HandshakeTokenMaxLen {
+		code = internal.BaseAuthResponseCodeRejected
+		reason = errors.ErrLengthOverflow.Error()
+		return nil, false
+	}
 	if req.SrcId == s.Id {
 		code = internal.BaseAuthResponseCodeInvalidSrcId
 		return nil, false
@@ -152,33 +273,173 @@ func (s *Server) Auth(native net.Conn) (c *conn.Conn, success bool) {
 		code = internal.BaseAuthResponseCodeInvalidDestId
 		return nil, false
 	}
-	if !internal.BytesEqual(s.hashKey, req.Key) {
-		code = internal.BaseAuthResponseCodeInvalidKey
+	// 认证前先检查一次，避免为注定被拒绝的连接进行认证，接管已存在的连接时不增加连接数
+	if _, exist := s.GetConn(req.SrcId); !exist && s.busy(req.ConnType) {
+		code, retryAfter = internal.BaseAuthResponseCodeServerBusy, s.BusyRetryAfter
 		return nil, false
 	}
-	c = conn.NewConn(req.ConnType, s.Id, req.SrcId, native, s.recvChan, &s.recvLock, &s.idCounter, s.ReaderBufSize, s.WriterBufSize, s.WriterQueueSize, s.MaxMsgLen)
-	if !s.AddConn(c) {
+	// 认证通过后添加连接前可能被吊销，添加后与该值比较
+	revokeSeq := s.revokeSeq(req.SrcId)
+	info := &auth.Info{
+		LocalId:     s.Id,
+		RemoteId:    req.SrcId,
+		ConnType:    req.ConnType,
+		Conn:        native,
+		Credentials: auth.Credentials{Token: req.Token, Legacy: req.Legacy, Metadata: req.Metadata},
+	}
+	if ka, ok := s.authenticator.(auth.KeyAuthenticator); ok {
+		if keys := ka.Keys(info); len(keys) > 0 {
+			if info.Credentials.KeyVerified, code, err = s.verifyKey(native, req, version, keys); err != nil {
+				_ = native.Close()
+				return nil, false
+			}
+			if code != 0 {
+				return nil, false
+			}
+		}
+	}
+	// 挑战应答的证明与请求中的节点Id绑定，认证之后再分配
+	if req.SrcId == 0 && s.IdAllocator != nil && req.ConnType == conn.TypeClient && req.Version >= internal.HandshakeV2 {
+		if assignedId, err = s.IdAllocator.Allocate(s.idInUse); err != nil {
+			code = internal.BaseAuthResponseCodeRejected
+			reason = err.Error()
+			return nil, false
+		}
+		req.SrcId = assignedId
+		info.RemoteId = assignedId
+		revokeSeq = s.revokeSeq(assignedId)
+	}
+	// 分配节点Id之后再校验，证书需要绑定实际使用的节点Id
+	if s.CertBinding != nil {
+		if err = auth.VerifyCert(s.CertBinding, native, req.SrcId); err != nil {
+			code = internal.BaseAuthResponseCodeCertMismatch
+			reason = err.Error()
+			return nil, false
+		}
+	}
+	var identity *conn.Identity
+	if s.protect(nil, nil, nil, func() { identity, err = s.authenticator.Authenticate(info) }) {
+		err = errors.New("internal error")
+	}
+	if err != nil {
+		code = internal.BaseAuthResponseCodeRejected
+		reason = err.Error()
+		return nil, false
+	}
+	integrity = internal.NegotiateIntegrity(version, req.Integrity, s.Integrity)
+	c = conn.NewConn(req.ConnType, s.Id, req.SrcId, native, s.recvChan, &s.recvLock, &s.idCounter, s.ReaderBufSize, s.WriterBufSize, s.WriterQueueSize, internal.MinMsgLen(s.MaxMsgLen, req.MaxMsgLen),
+		conn.WithStreamManager(s.streams),
+		conn.WithIntegrity(integrity),
+		conn.WithCompressor(s.Compressor, s.CompressThreshold),
+		conn.WithPeerInfo(conn.PeerInfo{Version: req.Version, Features: req.Features, Protocols: req.Protocols, MaxMsgLen: req.MaxMsgLen, Codecs: req.Codecs}),
+		conn.WithPeerMetadata(req.Metadata),
+		conn.WithIdentity(identity),
+	)
+	old, added, busy := s.addConnLimit(c, s.MaxConnections, s.connLimit(req.ConnType), s.takeover)
+	if !added {
 		code = internal.BaseAuthResponseCodeSrcIdExists
+		if busy {
+			code, retryAfter = internal.BaseAuthResponseCodeServerBusy, s.BusyRetryAfter
+		}
 		return nil, false
 	}
+	// 在 Revoke 之前添加的连接由 Revoke 关闭，之后添加的在此拒绝
+	if s.revokeSeq(req.SrcId) != revokeSeq {
+		s.removeConn(c)
+		s.connDone(c)
+		if old != nil {
+			_ = old.CloseWithError(errors.ErrRevoked)
+		}
+		code, reason = internal.BaseAuthResponseCodeRejected, errors.ErrRevoked.Error()
+		return nil, false
+	}
+	if old != nil {
+		// 旧连接的OnClose执行完毕后再响应，保证新连接的OnConnect在其之后
+		_ = old.CloseWithError(errors.ErrConnReplaced)
+		s.waitConnDone(old, s.AuthTimeout)
+	}
 	code = internal.BaseAuthResponseCodeSuccess
 	return c, true
 }
 
+// verifyKey 使用共享密钥认证请求方，请求方持有keys中任意一个即可，挑战应答时先证明本节点持有密钥，
+// 返回请求方是否通过认证，code不为0时拒绝，err不为nil时连接已不可用
+func (s *Server) verifyKey(native net.Conn, req *internal.BaseAuthRequest, version uint8, keys [][]byte) (verified bool, code internal.BaseAuthResponseCode, err error) {
+	hashKeys := make([][]byte, len(keys))
+	for i, key := range keys {
+		hashKeys[i] = internal.Hash(key)
+	}
+	if len(req.Nonce) > 0 && req.Version >= internal.HandshakeV2 {
+		nonce := internal.NewNonce()
+		ch := &internal.BaseAuthChallenge{Nonce: nonce, Proofs: make([][]byte, len(hashKeys))}
+		for i, hashKey := range hashKeys {
+			ch.Proofs[i] = internal.ServerProof(hashKey, req.Nonce, nonce, req.SrcId, req.DstId)
+		}
+		if err = internal.DefaultAuthService.Challenge(native, version, ch); err != nil {
+			return false, 0, err
+		}
+		proof, err := internal.DefaultAuthService.ReadProof(native, s.AuthTimeout)
+		if err != nil {
+			return false, 0, err
+		}
+		for _, hashKey := range hashKeys {
+			if hmac.Equal(proof, internal.ClientProof(hashKey, req.Nonce, nonce, req.SrcId, req.DstId)) {
+				return true, 0, nil
+			}
+		}
+		return false, internal.BaseAuthResponseCodeInvalidKey, nil
+	}
+	if !req.Legacy {
+		return false, 0, nil
+	}
+	if !s.LegacyAuth {
+		return false, internal.BaseAuthResponseCodeLegacyAuthRejected, nil
+	}
+	for _, hashKey := range hashKeys {
+		if internal.BytesEqual(hashKey, req.Key) {
+			return true, 0, nil
+		}
+	}
+	return false, internal.BaseAuthResponseCodeInvalidKey, nil
+}
+
 func (s *Server) Handle(c *conn.Conn) {
-	s.OnConnect(c)
+	s.protect(c, nil, nil, func() { s.OnConnect(c) })
+	// 连接断开时取消该连接上所有正在处理的请求
+	connCtx, connCancel := context.WithCancel(context.Background())
 	for {
 		msg, err := c.ReadMessage()
 		if err != nil {
+			connCancel()
 			_ = c.Close()
-			s.RemoveConn(c.RemoteId())
-			s.OnClose(c, err)
+			c.ReleasePending()
+			if s.streams != nil {
+				s.streams.CloseSender(c, errors.ErrConnClosed)
+			}
+			s.removeConn(c)
+			if s.IdAllocator != nil {
+				if _, exist := s.GetConn(c.RemoteId()); !exist {
+					s.IdAllocator.Release(c.RemoteId())
+				}
+			}
+			s.protect(nil, nil, nil, func() { s.OnClose(c, err) })
+			s.connDone(c)
 			return
 		}
+		if !s.validSrcId(c, msg) {
+			atomic.AddUint64(&s.srcIdViolations, 1)
+			if s.CloseOnSrcIdViolation {
+				_ = c.Close()
+			}
+			continue
+		}
 		if msg.Hop >= 254 || msg.Hop >= s.MaxRouteHop && s.MaxRouteHop > 0 {
 			continue
 		}
 		msg.Hop++
+		if !s.allow(c, msg) {
+			continue
+		}
 		if msg.DestId != s.Id {
 			// 本地存在
 			if dstConn, exist := s.GetConn(msg.DestId); exist {
@@ -201,6 +462,12 @@ func (s *Server) Handle(c *conn.Conn) {
 		case message.MsgType_KeepaliveASK:
 			_ = c.SendType(message.MsgType_KeepaliveACK, nil)
 		case message.MsgType_KeepaliveACK:
+		case message.MsgType_GoAway:
+			c.SetGoAway()
+			// 桥接节点即将关闭，撤回经过该节点的路由
+			if c.ConnType() == conn.TypeServer {
+				s.RemoveRouteWithVia(c.RemoteId())
+			}
 		case message.MsgType_Response:
 			s.recvLock.Lock()
 			ch, ok := s.recvChan[msg.Id]
@@ -209,51 +476,188 @@ func (s *Server) Handle(c *conn.Conn) {
 				delete(s.recvChan, msg.Id)
 			}
 			s.recvLock.Unlock()
+		case message.MsgType_Cancel:
+			s.inflight.Cancel(msg.SrcId, msg.Id)
+		case message.MsgType_Stream:
+			if s.streams != nil {
+				s.streams.Handle(c, msg, s.acceptStream(c))
+			}
 		default:
-			s.OnMessage(reply.NewReply(c, msg.Id, msg.SrcId), msg)
+			atomic.AddInt64(&s.handling, 1)
+			// 在分发前创建上下文，排队中的请求同样可以被取消
+			ctx, cancel := s.inflight.Context(connCtx, msg.SrcId, msg.Id, msg.Deadline)
+			s.dispatcher.Dispatch(msg.SrcId, func() {
+				defer func() {
+					cancel()
+					atomic.AddInt64(&s.handling, -1)
+				}()
+				r := reply.NewReplyWithContext(ctx, c, msg.Id, msg.SrcId)
+				s.protect(c, r, msg, func() { s.OnMessage(r, msg) })
+			})
+		}
+	}
+}
+
+// allow 按 ACL 检查消息，拒绝请求时回复 message.StateCode_Forbidden，拒绝单向消息（message.Message.NoReply）和流的帧时直接丢弃。
+// 响应和流的接受方发出的帧按请求的方向检查，即目的节点能否向源节点发送消息，取消请求需要源节点能向目的节点发送消息，三者被拒绝时直接丢弃；
+// 发给本节点的保活、GoAway消息以及邻居节点之间点对点发送的 Protocols 协议消息（例如路由协议）不检查
+func (s *Server) allow(c *conn.Conn, m *message.Message) bool {
+	if s.ACL == nil {
+		return true
+	}
+	switch m.Type {
+	case message.MsgType_Response:
+		return acl.AllowAny(s.ACL, m.DestId, m.SrcId)
+	case message.MsgType_Cancel:
+		return acl.AllowAny(s.ACL, m.SrcId, m.DestId)
+	case message.MsgType_Stream:
+		// 接受方发出的数据和窗口更新按发起流的方向检查
+		if stream.FromAcceptor(m) {
+			return acl.AllowAny(s.ACL, m.DestId, m.SrcId)
+		}
+	case message.MsgType_KeepaliveASK, message.MsgType_KeepaliveACK, message.MsgType_GoAway:
+		if m.DestId == s.Id {
+			return true
+		}
+	default:
+		if s.linkProtocol(c, m) {
+			return true
+		}
+	}
+	if s.ACL.Allow(m.SrcId, m.DestId, m.Type) {
+		return true
+	}
+	if m.Type != message.MsgType_Stream && !m.NoReply {
+		reply.NewReply(c, m.Id, m.SrcId).Write(message.StateCode_Forbidden, nil)
+	}
+	return false
+}
+
+// linkProtocol 消息是否为桥接的邻居节点发给本节点的 Protocols 协议消息
+func (s *Server) linkProtocol(c *conn.Conn, m *message.Message) bool {
+	if c.ConnType() != conn.TypeServer || m.SrcId != c.RemoteId() || m.DestId != s.Id {
+		return false
+	}
+	for _, typ := range s.Protocols {
+		if typ == m.Type {
+			return true
 		}
 	}
+	return false
+}
+
+// acceptStream 返回接受流的回调，Handler未实现 StreamHandler 或未设置流处理器时返回nil拒绝对端发起的流
+func (s *Server) acceptStream(c *conn.Conn) func(st *stream.Stream) {
+	if s.Handler == Handler(&s.manager) && s.manager.streamHandler() == nil {
+		return nil
+	}
+	h, ok := s.Handler.(StreamHandler)
+	if !ok {
+		return nil
+	}
+	return func(st *stream.Stream) {
+		go func() {
+			defer st.Close()
+			s.protect(c, nil, nil, func() { h.OnStream(st) })
+		}()
+	}
+}
+
+// protect 执行用户回调f并捕获panic，r不为nil时回复 message.StateCode_InternalError，返回是否发生panic
+func (s *Server) protect(c *conn.Conn, r *reply.Reply, m *message.Message, f func()) (panicked bool) {
+	defer func() {
+		v := recover()
+		if v == nil {
+			return
+		}
+		panicked = true
+		stack := debug.Stack()
+		if r != nil {
+			_ = r.Write(message.StateCode_InternalError, nil)
+		}
+		if s.PanicHandler != nil {
+			s.PanicHandler(c, m, v, stack)
+		} else {
+			log.Printf("node: panic in handler: %v\n%s", v, stack)
+		}
+		if c != nil && s.CloseOnPanic {
+			// 关闭前写出已回复的 message.StateCode_InternalError
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			_ = c.Flush(ctx)
+			cancel()
+			_ = c.Close()
+		}
+	}()
+	f()
+	return false
 }
 
-func (s *Server) RequestTo(ctx context.Context, dst uint32, data []byte) (int16, []byte, error) {
-	return s.RequestTypeTo(ctx, message.MsgType_Default, dst, data)
+func (s *Server) RequestTo(ctx context.Context, dst uint32, data []byte, md ...message.Metadata) (int16, []byte, error) {
+	return s.RequestTypeTo(ctx, message.MsgType_Default, dst, data, md...)
 }
 
-func (s *Server) RequestTypeTo(ctx context.Context, typ uint8, dst uint32, data []byte) (int16, []byte, error) {
+func (s *Server) RequestTypeTo(ctx context.Context, typ uint8, dst uint32, data []byte, md ...message.Metadata) (int16, []byte, error) {
 	return s.RequestMessage(ctx, &message.Message{
-		Type:   typ,
-		Id:     atomic.AddUint32(&s.idCounter, 1),
-		SrcId:  s.Id,
-		DestId: dst,
-		Data:   data,
+		Type:     typ,
+		Id:       atomic.AddUint32(&s.idCounter, 1),
+		SrcId:    s.Id,
+		DestId:   dst,
+		Data:     data,
+		Metadata: message.MergeMetadata(md...),
 	})
 }
 
 func (s *Server) RequestMessage(ctx context.Context, msg *message.Message) (int16, []byte, error) {
+	code, resp, err := s.RequestResponse(ctx, msg)
+	if resp == nil {
+		return code, nil, err
+	}
+	return code, resp.Data, err
+}
+
+// RequestResponse 同 RequestMessage，返回完整的响应消息，响应消息的Data不包含状态码，可用于获取响应的元数据
+func (s *Server) RequestResponse(ctx context.Context, msg *message.Message) (int16, *message.Message, error) {
 	conn, ok := s.GetConn(msg.DestId)
 	if ok {
-		return conn.RequestMessage(ctx, msg)
+		return conn.RequestResponse(ctx, msg)
 	}
 	route, ok := s.GetRoute(msg.DestId)
 	if ok {
 		if conn, ok = s.GetConn(route.Via); ok {
-			return conn.RequestMessage(ctx, msg)
+			return conn.RequestResponse(ctx, msg)
 		}
 	}
 	return message.StateCode_NodeNotExist, nil, nil
 }
 
-func (s *Server) SendTo(dst uint32, data []byte) error {
-	return s.SendTypeTo(message.MsgType_Default, dst, data)
+// OpenStreamTo 向dst节点发起流，dst不是直连节点时经过路由转发
+func (s *Server) OpenStreamTo(ctx context.Context, dst uint32) (*stream.Stream, error) {
+	conn, ok := s.GetConn(dst)
+	if ok {
+		return conn.OpenStreamTo(ctx, dst)
+	}
+	route, ok := s.GetRoute(dst)
+	if ok {
+		if conn, ok = s.GetConn(route.Via); ok {
+			return conn.OpenStreamTo(ctx, dst)
+		}
+	}
+	return nil, errors.ErrNodeNotExist
+}
+
+func (s *Server) SendTo(dst uint32, data []byte, md ...message.Metadata) error {
+	return s.SendTypeTo(message.MsgType_Default, dst, data, md...)
 }
 
-func (s *Server) SendTypeTo(typ uint8, dst uint32, data []byte) error {
+func (s *Server) SendTypeTo(typ uint8, dst uint32, data []byte, md ...message.Metadata) error {
 	return s.SendMessage(&message.Message{
-		Type:   typ,
-		Id:     atomic.AddUint32(&s.idCounter, 1),
-		SrcId:  s.Id,
-		DestId: dst,
-		Data:   data,
+		Type:     typ,
+		Id:       atomic.AddUint32(&s.idCounter, 1),
+		SrcId:    s.Id,
+		DestId:   dst,
+		Data:     data,
+		Metadata: message.MergeMetadata(md...),
+		NoReply:  true,
 	})
 }
 
@@ -271,6 +675,16 @@ func (s *Server) SendMessage(msg *message.Message) error {
 	return errors.ErrNodeNotExist
 }
 
+// Revoke 吊销节点的凭据并关闭与其的直接连接，Authenticator 实现 auth.Revoker 时从中删除该节点的凭据，之后该节点无法再通过认证
+func (s *Server) Revoke(id uint32) {
+	if r, ok := s.Authenticator.(auth.Revoker); ok {
+		r.Revoke(id)
+	}
+	if c, ok := s.revoke(id); ok {
+		_ = c.CloseWithError(errors.ErrRevoked)
+	}
+}
+
 func (s *Server) Bridge(native net.Conn, remoteId uint32, remoteAuthKey []byte) (err error) {
 	defer func() {
 		if err != nil {
@@ -283,23 +697,68 @@ func (s *Server) Bridge(native net.Conn, remoteId uint32, remoteAuthKey []byte)
 	if _, ok := s.GetConn(remoteId); ok {
 		return errors.BridgeRemoteIdExistErr
 	}
-	err = internal.DefaultAuthService.Request(native, &internal.BaseAuthRequest{
-		ConnType: conn.TypeServer,
-		SrcId:    s.NodeId(),
-		DstId:    remoteId,
-		Key:      remoteAuthKey,
-	})
+	info := &auth.Info{LocalId: s.Id, RemoteId: remoteId, Outbound: true, Conn: native}
+	a := s.Authenticator
+	if a == nil {
+		a = auth.Key(remoteAuthKey)
+	}
+	// 未指定对端密钥时使用 Authenticator 提供的密钥，Authenticator 不使用共享密钥时不进行挑战应答认证
+	var keys [][]byte
+	if remoteAuthKey != nil {
+		keys = [][]byte{remoteAuthKey}
+	} else if ka, ok := a.(auth.KeyAuthenticator); ok {
+		keys = ka.Keys(info)
+	}
+	req := &internal.BaseAuthRequest{
+		ConnType:  conn.TypeServer,
+		SrcId:     s.NodeId(),
+		DstId:     remoteId,
+		Key:       firstKey(keys),
+		Token:     s.Token,
+		Integrity: s.Integrity,
+		Version:   internal.NegotiateVersion(s.HandshakeVersion),
+		Features:  conn.Features,
+		Protocols: s.Protocols,
+		MaxMsgLen: s.MaxMsgLen,
+		Codecs:    compress.Codecs(),
+	}
+	if len(req.Token) > internal.HandshakeTokenMaxLen {
+		return errors.ErrLengthOverflow
+	}
+	// v1握手只支持静态密钥认证
+	if req.Version < internal.HandshakeV2 {
+		req.Legacy = true
+	} else if len(keys) > 0 {
+		req.Nonce = internal.NewNonce()
+	}
+	resp, verified, err := internal.DefaultAuthService.Handshake(native, req, keys, s.AuthTimeout)
 	if err != nil {
 		return err
 	}
-	resp, err := internal.DefaultAuthService.ReadResponse(native, s.AuthTimeout)
-	if err != nil {
+	if err = resp.Err(); err != nil {
 		return err
 	}
-	if resp.Code != internal.BaseAuthResponseCodeSuccess {
-		return errors.New(resp.Code.String())
+	if s.CertBinding != nil {
+		if err = auth.VerifyCert(s.CertBinding, native, remoteId); err != nil {
+			return err
+		}
 	}
-	c := conn.NewConn(resp.ConnType, s.Id, remoteId, native, s.recvChan, &s.recvLock, &s.idCounter, s.ReaderBufSize, s.WriterBufSize, s.WriterQueueSize, s.MaxMsgLen)
+	info.ConnType = resp.ConnType
+	info.Credentials = auth.Credentials{Token: resp.Token, KeyVerified: verified, Legacy: req.Legacy}
+	var identity *conn.Identity
+	if s.protect(nil, nil, nil, func() { identity, err = a.Authenticate(info) }) {
+		err = errors.New("internal error")
+	}
+	if err != nil {
+		return err
+	}
+	c := conn.NewConn(resp.ConnType, s.Id, remoteId, native, s.recvChan, &s.recvLock, &s.idCounter, s.ReaderBufSize, s.WriterBufSize, s.WriterQueueSize, internal.MinMsgLen(s.MaxMsgLen, resp.MaxMsgLen),
+		conn.WithStreamManager(s.streams),
+		conn.WithIntegrity(resp.Integrity),
+		conn.WithCompressor(s.Compressor, s.CompressThreshold),
+		conn.WithPeerInfo(conn.PeerInfo{Version: resp.Version, Features: resp.Features, Protocols: resp.Protocols, MaxMsgLen: resp.MaxMsgLen, Codecs: resp.Codecs}),
+		conn.WithIdentity(identity),
+	)
 	if !s.AddConn(c) {
 		return errors.BridgeRemoteIdExistErr
 	}
@@ -307,6 +766,47 @@ func (s *Server) Bridge(native net.Conn, remoteId uint32, remoteAuthKey []byte)
 	return nil
 }
 
+// AddOnAccept 在当前实例上注册回调，Serve的h为nil时生效
+func (s *Server) AddOnAccept(fn ...OnAcceptFunc) {
+	s.manager.AddOnAccept(fn...)
+}
+
+func (s *Server) AddOnConnect(fn ...OnConnectFunc) {
+	s.manager.AddOnConnect(fn...)
+}
+
+func (s *Server) AddOnMessage(fn ...OnMessageFunc) {
+	s.manager.AddOnMessage(fn...)
+}
+
+func (s *Server) AddOnMessageWithType(typ uint8, fn OnMessageFunc) {
+	s.manager.AddOnMessageWithType(typ, fn)
+}
+
+// SetOnMessageWithType 替换当前实例上typ类型消息的全部处理器
+func (s *Server) SetOnMessageWithType(typ uint8, fn ...OnMessageFunc) {
+	s.manager.SetOnMessageWithType(typ, fn...)
+}
+
+// RemoveOnMessageWithType 移除当前实例上typ类型消息的全部处理器
+func (s *Server) RemoveOnMessageWithType(typ uint8) bool {
+	return s.manager.RemoveOnMessageWithType(typ)
+}
+
+func (s *Server) AddOnClose(fn ...OnCloseFunc) {
+	s.manager.AddOnClose(fn...)
+}
+
+// SetOnStream 设置当前实例上的流处理器
+func (s *Server) SetOnStream(fn OnStreamFunc) {
+	s.manager.SetOnStream(fn)
+}
+
+// Use 在当前实例上添加OnMessage中间件
+func (s *Server) Use(mw ...middleware.Middleware) {
+	s.manager.Use(mw...)
+}
+
 func (s *Server) NodeId() uint32 {
 	return s.Id
 }
@@ -322,6 +822,38 @@ func (s *Server) Close() error {
 	return nil
 }
 
+// Shutdown 优雅关闭服务：停止接受新连接，向所有连接发送 message.MsgType_GoAway，等待正在执行的OnMessage返回、
+// 写队列中的数据写出后关闭所有连接，ctx结束时立即关闭所有连接并返回ctx.Err()
+func (s *Server) Shutdown(ctx context.Context) error {
+	if !atomic.CompareAndSwapUint32(&s.state, 1, 2) {
+		return nil
+	}
+	err := s.Listener.Close()
+	defer func() {
+		for _, c := range s.GetAllConn() {
+			_ = c.Close()
+		}
+	}()
+	for _, c := range s.GetAllConn() {
+		_ = c.SendType(message.MsgType_GoAway, nil)
+	}
+	tick := time.NewTicker(time.Millisecond * 10)
+	defer tick.Stop()
+	for atomic.LoadInt64(&s.handling) > 0 {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-tick.C:
+		}
+	}
+	for _, c := range s.GetAllConn() {
+		if e := c.Flush(ctx); e != nil && ctx.Err() != nil {
+			return ctx.Err()
+		}
+	}
+	return err
+}
+
 func (s *Server) StartKeepalive(ctx context.Context) {
 	if s.KeepaliveInterval <= 0 || s.KeepaliveTimeout <= 0 {
 		return
@@ -339,7 +871,7 @@ func (s *Server) StartKeepalive(ctx context.Context) {
 		var err error
 		var diff int64
 		for t := range tick.C {
-			if s.state == 0 {
+			if atomic.LoadUint32(&s.state) == 0 {
 				return
 			}
 			for _, conn := range s.GetAllConn() {
@@ -378,3 +910,10 @@ func (s *Server) CreateMessage(typ uint8, src uint32, dst uint32, data []byte) *
 		Data:   data,
 	}
 }
+
+func firstKey(keys [][]byte) []byte {
+	if len(keys) == 0 {
+		return nil
+	}
+	return keys[0]
+}
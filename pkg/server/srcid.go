@@ -0,0 +1,41 @@
+package server
+
+import (
+	"github.com/Li-giegie/node/pkg/conn"
+	"github.com/Li-giegie/node/pkg/message"
+	"sync/atomic"
+)
+
+// SrcIdCheck 接收消息时的源节点Id校验模式，校验失败的消息被丢弃
+type SrcIdCheck uint8
+
+const (
+	// SrcIdCheckOff 不校验
+	SrcIdCheckOff SrcIdCheck = iota
+	// SrcIdCheckClient 客户端连接上消息的SrcId必须为对端节点Id，桥接连接不校验
+	SrcIdCheckClient
+	// SrcIdCheckStrict 在 SrcIdCheckClient 的基础上，桥接连接上消息的源节点必须可以经由该邻居节点到达，见 router.Router.Reachable，需要启用动态路由协议或添加静态路由
+	SrcIdCheckStrict
+)
+
+// validSrcId 按 SrcIdCheck 校验消息的源节点Id
+func (s *Server) validSrcId(c *conn.Conn, m *message.Message) bool {
+	if s.SrcIdCheck == SrcIdCheckOff {
+		return true
+	}
+	switch c.ConnType() {
+	case conn.TypeClient:
+		return m.SrcId == c.RemoteId()
+	case conn.TypeServer:
+		if s.SrcIdCheck < SrcIdCheckStrict {
+			return true
+		}
+		return m.SrcId != s.Id && s.Reachable(m.SrcId, c.RemoteId())
+	}
+	return true
+}
+
+// SrcIdViolations 源节点Id校验失败的消息数
+func (s *Server) SrcIdViolations() uint64 {
+	return atomic.LoadUint64(&s.srcIdViolations)
+}
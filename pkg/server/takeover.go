@@ -0,0 +1,33 @@
+package server
+
+import (
+	"github.com/Li-giegie/node/pkg/conn"
+	"time"
+)
+
+// TakeoverPolicy 节点Id已存在连接时（例如对端断线重连而旧连接尚未被保活检测关闭）新连接的处理策略
+type TakeoverPolicy uint8
+
+const (
+	// TakeoverReject 拒绝新连接
+	TakeoverReject TakeoverPolicy = iota
+	// TakeoverReplace 关闭旧连接，由新连接接管节点Id，同一节点Id的两个实例都启用断线重连时会互相接管
+	TakeoverReplace
+	// TakeoverReplaceIdle 旧连接空闲超过 Server.TakeoverIdle 时由新连接接管，否则拒绝新连接
+	TakeoverReplaceIdle
+)
+
+// takeover 按 TakeoverPolicy 决定新连接能否接管旧连接old
+func (s *Server) takeover(old *conn.Conn) bool {
+	switch s.Takeover {
+	case TakeoverReplace:
+		return true
+	case TakeoverReplaceIdle:
+		idle := s.TakeoverIdle
+		if idle <= 0 {
+			idle = s.KeepaliveTimeout
+		}
+		return time.Now().UnixNano()-int64(old.Activate()) > int64(idle)
+	}
+	return false
+}
@@ -0,0 +1,500 @@
+package stream
+
+import (
+	"context"
+	"encoding/binary"
+	"github.com/Li-giegie/node/pkg/errors"
+	"github.com/Li-giegie/node/pkg/message"
+	"io"
+	"sync"
+	"time"
+)
+
+// 帧类型，位于 message.MsgType_Stream 消息Data的第一个字节，最高位表示帧由流的发起方发出，同一个流的所有帧使用相同的消息Id
+const (
+	FrameOpen      uint8 = iota + 1 // 发起流，4Byte发起方的接收窗口
+	FrameAck                        // 接受流，4Byte接受方的接收窗口
+	FrameData                       // 数据
+	FrameWindow                     // 4Byte接收窗口增量
+	FrameClose                      // 半关闭，发送方不再发送数据
+	FrameReset                      // 终止流
+	frameInitiator uint8 = 0x80
+)
+
+const (
+	// DefaultWindow 默认的接收窗口大小，发送方在未收到窗口更新前最多发送的字节数
+	DefaultWindow = 256 * 1024
+	// DefaultChunkSize 数据帧的最大长度，连接的MaxMsgLen较小时会相应减小
+	DefaultChunkSize = 32 * 1024
+	// DefaultIdleTimeout 默认的空闲超时，超过该时间未收到对端的任何帧时终止流
+	DefaultIdleTimeout = time.Minute * 5
+)
+
+// FromAcceptor 流帧是否由流的接受方发出，即与发起流的方向相反
+func FromAcceptor(m *message.Message) bool {
+	return len(m.Data) > 0 && m.Data[0]&frameInitiator == 0
+}
+
+// Sender 流帧的发送方，通常为 *conn.Conn
+type Sender interface {
+	SendMessage(m *message.Message) error
+	CreateMessageId() uint32
+	LocalId() uint32
+	MaxMsgLen() uint32
+}
+
+type key struct {
+	peer      uint32
+	id        uint32
+	initiator bool
+}
+
+// NewManager 创建流管理器，window为每个流的接收窗口，0时使用 DefaultWindow，
+// idleTimeout为流的空闲超时，0时使用 DefaultIdleTimeout，小于0时不检查
+func NewManager(window uint32, idleTimeout time.Duration) *Manager {
+	if window == 0 {
+		window = DefaultWindow
+	}
+	if idleTimeout == 0 {
+		idleTimeout = DefaultIdleTimeout
+	}
+	return &Manager{window: window, idleTimeout: idleTimeout, streams: make(map[key]*Stream)}
+}
+
+// Manager 管理一个节点上的所有流，按对端节点Id、流Id和发起方区分
+type Manager struct {
+	window      uint32
+	idleTimeout time.Duration
+	l           sync.Mutex
+	streams     map[key]*Stream
+}
+
+// Open 向dst发起流，阻塞直至对端接受、拒绝或ctx结束，对端拒绝时返回 errors.ErrStreamRefused
+func (m *Manager) Open(ctx context.Context, sender Sender, dst uint32) (*Stream, error) {
+	s := m.newStream(sender, dst, sender.CreateMessageId(), true, 0)
+	m.l.Lock()
+	m.streams[s.key()] = s
+	m.l.Unlock()
+	if err := s.sendFrame(FrameOpen, uint32Bytes(m.window)); err != nil {
+		s.finish(err)
+		return nil, err
+	}
+	select {
+	case <-s.acked:
+		return s, nil
+	case <-s.done:
+		return nil, s.err
+	case <-ctx.Done():
+		_ = s.Reset()
+		return nil, ctx.Err()
+	}
+}
+
+// Handle 处理收到的 message.MsgType_Stream 消息，accept为nil时拒绝对端发起的流，accept不应阻塞
+func (m *Manager) Handle(sender Sender, msg *message.Message, accept func(s *Stream)) {
+	if len(msg.Data) == 0 {
+		return
+	}
+	kind := msg.Data[0] &^ frameInitiator
+	payload := msg.Data[1:]
+	k := key{peer: msg.SrcId, id: msg.Id, initiator: msg.Data[0]&frameInitiator == 0}
+	m.l.Lock()
+	s, ok := m.streams[k]
+	if !ok && kind == FrameOpen && accept != nil && len(payload) >= 4 {
+		s = m.newStream(sender, msg.SrcId, msg.Id, false, binary.LittleEndian.Uint32(payload))
+		m.streams[k] = s
+		m.l.Unlock()
+		if err := s.sendFrame(FrameAck, uint32Bytes(m.window)); err != nil {
+			s.finish(err)
+			return
+		}
+		accept(s)
+		return
+	}
+	m.l.Unlock()
+	if !ok {
+		// 流不存在或不接受对端发起的流
+		if kind != FrameReset {
+			flag := FrameReset
+			if k.initiator {
+				flag |= frameInitiator
+			}
+			_ = sender.SendMessage(&message.Message{
+				Type:   message.MsgType_Stream,
+				Id:     msg.Id,
+				SrcId:  sender.LocalId(),
+				DestId: msg.SrcId,
+				Data:   []byte{flag},
+			})
+		}
+		return
+	}
+	s.handle(kind, payload)
+}
+
+// CloseSender 终止所有通过sender收发的流，连接断开时调用
+func (m *Manager) CloseSender(sender Sender, err error) {
+	var list []*Stream
+	m.l.Lock()
+	for _, s := range m.streams {
+		if s.sender == sender {
+			list = append(list, s)
+		}
+	}
+	m.l.Unlock()
+	for _, s := range list {
+		s.finish(err)
+	}
+}
+
+func (m *Manager) remove(s *Stream) {
+	m.l.Lock()
+	if m.streams[s.key()] == s {
+		delete(m.streams, s.key())
+	}
+	m.l.Unlock()
+}
+
+func (m *Manager) newStream(sender Sender, remoteId, id uint32, initiator bool, credit uint32) *Stream {
+	chunk := DefaultChunkSize
+	// 预留消息头及扩展字段的长度
+	if n := int(sender.MaxMsgLen()) - message.MsgHeaderLenFlags - 64; sender.MaxMsgLen() > 0 && n < chunk {
+		chunk = n
+	}
+	if chunk > int(m.window) {
+		chunk = int(m.window)
+	}
+	s := &Stream{
+		id:        id,
+		localId:   sender.LocalId(),
+		remoteId:  remoteId,
+		initiator: initiator,
+		chunk:     chunk,
+		window:    m.window,
+		sender:    sender,
+		m:         m,
+		credit:    int64(credit),
+		acked:     make(chan struct{}),
+		done:      make(chan struct{}),
+		readC:     make(chan struct{}, 1),
+		writeC:    make(chan struct{}, 1),
+	}
+	if !initiator {
+		close(s.acked)
+	}
+	// 转发路径中断时对端收不到 FrameReset，超时后终止流
+	if m.idleTimeout > 0 {
+		s.idle = time.AfterFunc(m.idleTimeout, s.expire)
+	}
+	return s
+}
+
+// Stream 节点间的双向字节流，实现 io.ReadWriteCloser，Read、Write可以在不同的goroutine中同时调用
+type Stream struct {
+	id        uint32
+	localId   uint32
+	remoteId  uint32
+	initiator bool
+	chunk     int
+	window    uint32
+	sender    Sender
+	m         *Manager
+	acked     chan struct{}
+	done      chan struct{}
+	readC     chan struct{}
+	writeC    chan struct{}
+	l         sync.Mutex
+	buf       [][]byte
+	bufLen    int
+	consumed  uint32
+	credit    int64
+	finSent   bool
+	finRecv   bool
+	// 本端已调用Close或Reset
+	closed bool
+	err    error
+	idle   *time.Timer
+}
+
+func (s *Stream) key() key {
+	return key{peer: s.remoteId, id: s.id, initiator: s.initiator}
+}
+
+func (s *Stream) handle(kind uint8, payload []byte) {
+	if s.idle != nil {
+		s.idle.Reset(s.m.idleTimeout)
+	}
+	switch kind {
+	case FrameAck:
+		if len(payload) < 4 || !s.initiator {
+			return
+		}
+		s.l.Lock()
+		select {
+		case <-s.acked:
+		default:
+			s.credit = int64(binary.LittleEndian.Uint32(payload))
+			close(s.acked)
+		}
+		s.l.Unlock()
+	case FrameData:
+		s.l.Lock()
+		if s.finRecv || s.closed || s.err != nil {
+			s.l.Unlock()
+			return
+		}
+		if s.bufLen+len(payload) > int(s.window) {
+			// 对端未遵守流量控制
+			s.l.Unlock()
+			_ = s.Reset()
+			return
+		}
+		s.buf = append(s.buf, payload)
+		s.bufLen += len(payload)
+		s.l.Unlock()
+		notify(s.readC)
+	case FrameWindow:
+		if len(payload) < 4 {
+			return
+		}
+		s.l.Lock()
+		s.credit += int64(binary.LittleEndian.Uint32(payload))
+		s.l.Unlock()
+		notify(s.writeC)
+	case FrameClose:
+		s.l.Lock()
+		s.finRecv = true
+		finished := s.finSent
+		s.l.Unlock()
+		notify(s.readC)
+		if finished {
+			s.finish(nil)
+		}
+	case FrameReset:
+		err := errors.ErrStreamReset
+		select {
+		case <-s.acked:
+		default:
+			err = errors.ErrStreamRefused
+		}
+		s.finish(err)
+	}
+}
+
+// finish 结束流并从管理器中移除，err为nil表示双方均已正常关闭
+func (s *Stream) finish(err error) {
+	s.l.Lock()
+	if s.err == nil {
+		s.err = err
+	}
+	select {
+	case <-s.done:
+	default:
+		close(s.done)
+	}
+	s.l.Unlock()
+	if s.idle != nil {
+		s.idle.Stop()
+	}
+	s.m.remove(s)
+}
+
+// expire 空闲超时，终止流
+func (s *Stream) expire() {
+	select {
+	case <-s.done:
+		return
+	default:
+	}
+	_ = s.sendFrame(FrameReset, nil)
+	s.finish(errors.ErrStreamTimeout)
+}
+
+func (s *Stream) sendFrame(kind uint8, payload []byte) error {
+	if s.initiator {
+		kind |= frameInitiator
+	}
+	data := make([]byte, 1+len(payload))
+	data[0] = kind
+	copy(data[1:], payload)
+	return s.sender.SendMessage(&message.Message{
+		Type:   message.MsgType_Stream,
+		Id:     s.id,
+		SrcId:  s.localId,
+		DestId: s.remoteId,
+		Data:   data,
+	})
+}
+
+// Read 读取对端发送的数据，已接收的数据读取完毕后，对端半关闭时返回 io.EOF，流被终止时返回终止的原因
+func (s *Stream) Read(p []byte) (int, error) {
+	for {
+		s.l.Lock()
+		if s.closed {
+			s.l.Unlock()
+			return 0, errors.ErrStreamClosed
+		}
+		if s.bufLen > 0 {
+			var n int
+			for n < len(p) && len(s.buf) > 0 {
+				c := copy(p[n:], s.buf[0])
+				n += c
+				if c == len(s.buf[0]) {
+					s.buf = s.buf[1:]
+				} else {
+					s.buf[0] = s.buf[0][c:]
+				}
+			}
+			s.bufLen -= n
+			s.consumed += uint32(n)
+			var inc uint32
+			// 已读取的数据达到窗口的一半时通知对端
+			if s.consumed >= s.window/2 && !s.finRecv && s.err == nil {
+				inc, s.consumed = s.consumed, 0
+			}
+			s.l.Unlock()
+			if inc > 0 {
+				_ = s.sendFrame(FrameWindow, uint32Bytes(inc))
+			}
+			return n, nil
+		}
+		if s.finRecv {
+			s.l.Unlock()
+			return 0, io.EOF
+		}
+		if s.err != nil {
+			err := s.err
+			s.l.Unlock()
+			return 0, err
+		}
+		s.l.Unlock()
+		select {
+		case <-s.readC:
+		case <-s.done:
+		}
+	}
+}
+
+// Write 将p分块发送给对端，对端接收窗口用尽时阻塞
+func (s *Stream) Write(p []byte) (int, error) {
+	var n int
+	for n < len(p) {
+		s.l.Lock()
+		if s.closed {
+			s.l.Unlock()
+			return n, errors.ErrStreamClosed
+		}
+		if s.err != nil {
+			err := s.err
+			s.l.Unlock()
+			return n, err
+		}
+		if s.finSent {
+			s.l.Unlock()
+			return n, errors.ErrStreamClosed
+		}
+		if s.credit <= 0 {
+			s.l.Unlock()
+			select {
+			case <-s.writeC:
+			case <-s.done:
+			}
+			continue
+		}
+		size := len(p) - n
+		if size > s.chunk {
+			size = s.chunk
+		}
+		if int64(size) > s.credit {
+			size = int(s.credit)
+		}
+		s.credit -= int64(size)
+		s.l.Unlock()
+		if err := s.sendFrame(FrameData, p[n:n+size]); err != nil {
+			return n, err
+		}
+		n += size
+	}
+	return n, nil
+}
+
+// CloseWrite 半关闭，通知对端不再发送数据，之后仍可以读取对端发送的数据
+func (s *Stream) CloseWrite() error {
+	s.l.Lock()
+	if s.err != nil || s.finSent {
+		s.l.Unlock()
+		return nil
+	}
+	s.finSent = true
+	finished := s.finRecv
+	s.l.Unlock()
+	err := s.sendFrame(FrameClose, nil)
+	if finished {
+		s.finish(nil)
+	}
+	return err
+}
+
+// Close 关闭流，向对端发送 FrameClose，对端读取完已发送的数据后返回 io.EOF，本端丢弃未读取和之后收到的数据，
+// 之后的读写返回 errors.ErrStreamClosed，对端继续发送数据时收到 FrameReset
+func (s *Stream) Close() error {
+	err := s.CloseWrite()
+	s.discard()
+	s.finish(errors.ErrStreamClosed)
+	return err
+}
+
+// Reset 立即终止流，对端读取完已接收的数据后读写返回 errors.ErrStreamReset
+func (s *Stream) Reset() error {
+	s.l.Lock()
+	if s.err != nil {
+		s.l.Unlock()
+		return nil
+	}
+	s.l.Unlock()
+	err := s.sendFrame(FrameReset, nil)
+	s.discard()
+	s.finish(errors.ErrStreamClosed)
+	return err
+}
+
+// discard 本端不再读取数据，丢弃已接收的数据
+func (s *Stream) discard() {
+	s.l.Lock()
+	s.closed = true
+	s.buf = nil
+	s.bufLen = 0
+	s.l.Unlock()
+	notify(s.readC)
+	notify(s.writeC)
+}
+
+// Done 流结束（双方正常关闭、被终止或连接断开）时关闭
+func (s *Stream) Done() <-chan struct{} {
+	return s.done
+}
+
+func (s *Stream) Id() uint32 {
+	return s.id
+}
+
+func (s *Stream) LocalId() uint32 {
+	return s.localId
+}
+
+func (s *Stream) RemoteId() uint32 {
+	return s.remoteId
+}
+
+func notify(c chan struct{}) {
+	select {
+	case c <- struct{}{}:
+	default:
+	}
+}
+
+func uint32Bytes(n uint32) []byte {
+	b := make([]byte, 4)
+	binary.LittleEndian.PutUint32(b, n)
+	return b
+}
@@ -0,0 +1,195 @@
+package stream
+
+import (
+	"bytes"
+	"context"
+	"github.com/Li-giegie/node/pkg/errors"
+	"github.com/Li-giegie/node/pkg/message"
+	"io"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type pipeSender struct {
+	id    uint32
+	seq   uint32
+	queue chan *message.Message
+}
+
+func (p *pipeSender) SendMessage(m *message.Message) error {
+	p.queue <- m
+	return nil
+}
+
+func (p *pipeSender) CreateMessageId() uint32 {
+	return atomic.AddUint32(&p.seq, 1)
+}
+
+func (p *pipeSender) LocalId() uint32 {
+	return p.id
+}
+
+func (p *pipeSender) MaxMsgLen() uint32 {
+	return 0
+}
+
+// pipe 连接两个管理器，a发出的帧由b处理，反之亦然
+func pipe(a, b *Manager, accept func(s *Stream)) (*pipeSender, *pipeSender) {
+	sa := &pipeSender{id: 1, queue: make(chan *message.Message, 1024)}
+	sb := &pipeSender{id: 2, queue: make(chan *message.Message, 1024)}
+	go func() {
+		for m := range sa.queue {
+			b.Handle(sb, m, accept)
+		}
+	}()
+	go func() {
+		for m := range sb.queue {
+			a.Handle(sa, m, nil)
+		}
+	}()
+	return sa, sb
+}
+
+func TestStreamEcho(t *testing.T) {
+	a, b := NewManager(1024, 0), NewManager(1024, 0)
+	sa, _ := pipe(a, b, func(s *Stream) {
+		go func() {
+			defer s.Close()
+			_, _ = io.Copy(s, s)
+			_ = s.CloseWrite()
+		}()
+	})
+	s, err := a.Open(context.Background(), sa, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := bytes.Repeat([]byte("0123456789"), 1000)
+	go func() {
+		_, _ = s.Write(data)
+		_ = s.CloseWrite()
+	}()
+	out, err := io.ReadAll(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out, data) {
+		t.Fatal("echo mismatch", len(out))
+	}
+}
+
+func TestStreamRefused(t *testing.T) {
+	a, b := NewManager(0, 0), NewManager(0, 0)
+	sa, _ := pipe(a, b, nil)
+	if _, err := a.Open(context.Background(), sa, 2); err == nil {
+		t.Fatal("expected refused")
+	}
+}
+
+// slowRead 每次读取少量数据并等待，使数据在接收方缓存
+func slowRead(s *Stream) ([]byte, error) {
+	var out bytes.Buffer
+	buf := make([]byte, 512)
+	for {
+		time.Sleep(time.Millisecond)
+		n, err := s.Read(buf)
+		out.Write(buf[:n])
+		if err == io.EOF {
+			return out.Bytes(), nil
+		}
+		if err != nil {
+			return out.Bytes(), err
+		}
+	}
+}
+
+// 写入后直接Close，对端读取完已发送的数据后返回 io.EOF
+func TestStreamCloseDrain(t *testing.T) {
+	a, b := NewManager(4096, 0), NewManager(4096, 0)
+	type result struct {
+		data []byte
+		err  error
+	}
+	resC := make(chan result, 1)
+	sa, _ := pipe(a, b, func(s *Stream) {
+		go func() {
+			data, err := slowRead(s)
+			resC <- result{data, err}
+			_ = s.Close()
+		}()
+	})
+	s, err := a.Open(context.Background(), sa, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := bytes.Repeat([]byte("0123456789"), 10000)
+	if _, err = io.Copy(s, bytes.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = s.Read(make([]byte, 1)); err == nil || err.Error() != errors.ErrStreamClosed.Error() {
+		t.Fatalf("read after close = %v", err)
+	}
+	select {
+	case r := <-resC:
+		if r.err != nil || !bytes.Equal(r.data, data) {
+			t.Fatalf("received %d bytes, err = %v", len(r.data), r.err)
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatal("timeout")
+	}
+}
+
+// 被终止时先返回已接收的数据
+func TestStreamResetDrain(t *testing.T) {
+	a, b := NewManager(0, 0), NewManager(0, 0)
+	accepted := make(chan *Stream, 1)
+	sa, _ := pipe(a, b, func(s *Stream) { accepted <- s })
+	s, err := a.Open(context.Background(), sa, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	peer := <-accepted
+	_, _ = s.Write([]byte("hello"))
+	_ = s.Reset()
+	<-peer.Done()
+	buf := make([]byte, 16)
+	if n, err := peer.Read(buf); err != nil || string(buf[:n]) != "hello" {
+		t.Fatalf("read = %q, %v", buf[:n], err)
+	}
+	if _, err = peer.Read(buf); err == nil || err.Error() != errors.ErrStreamReset.Error() {
+		t.Fatalf("read after drain = %v", err)
+	}
+}
+
+func TestStreamIdleTimeout(t *testing.T) {
+	a, b := NewManager(0, time.Millisecond*50), NewManager(0, -1)
+	accepted := make(chan *Stream, 1)
+	sa, _ := pipe(a, b, func(s *Stream) { accepted <- s })
+	s, err := a.Open(context.Background(), sa, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	peer := <-accepted
+	// 对端存活时收到的帧重置空闲计时
+	for i := 0; i < 4; i++ {
+		time.Sleep(time.Millisecond * 20)
+		_, _ = peer.Write([]byte("x"))
+	}
+	select {
+	case <-s.Done():
+		t.Fatal("active stream expired")
+	default:
+	}
+	// 对端不再发送任何帧，读取在超时后返回
+	start := time.Now()
+	buf := make([]byte, 16)
+	for err == nil {
+		_, err = s.Read(buf)
+	}
+	if err.Error() != errors.ErrStreamTimeout.Error() || time.Since(start) > time.Second {
+		t.Fatalf("err = %v after %v", err, time.Since(start))
+	}
+}
@@ -5,8 +5,10 @@ import (
 	"crypto/tls"
 	"github.com/Li-giegie/node/pkg/conn"
 	"github.com/Li-giegie/node/pkg/message"
+	"github.com/Li-giegie/node/pkg/middleware"
 	"github.com/Li-giegie/node/pkg/router"
 	"github.com/Li-giegie/node/pkg/server"
+	"github.com/Li-giegie/node/pkg/stream"
 	"net"
 )
 
@@ -19,6 +21,22 @@ type Server interface {
 	ListenAndServe(address string, h server.Handler, conf ...*tls.Config) (err error)
 	//Bridge 从当前节点桥接一个节点,组成一个更大的域，如果要完整启用该功能则需要开启节点动态发现协议
 	Bridge(conn net.Conn, remoteId uint32, remoteAuthKey []byte) (err error)
+	// Revoke 吊销节点的凭据并关闭与其的连接，需要 Authenticator 实现 auth.Revoker，例如 auth.KeyStore
+	Revoke(id uint32)
+	// AddOnAccept 在当前实例上注册回调，Serve的h为nil时生效，实例上未注册的回调使用全局 server.Default
+	AddOnAccept(fn ...server.OnAcceptFunc)
+	AddOnConnect(fn ...server.OnConnectFunc)
+	AddOnMessage(fn ...server.OnMessageFunc)
+	AddOnMessageWithType(typ uint8, fn server.OnMessageFunc)
+	// SetOnMessageWithType 替换typ类型消息的全部处理器
+	SetOnMessageWithType(typ uint8, fn ...server.OnMessageFunc)
+	// RemoveOnMessageWithType 移除typ类型消息的全部处理器
+	RemoveOnMessageWithType(typ uint8) bool
+	AddOnClose(fn ...server.OnCloseFunc)
+	// SetOnStream 设置流处理器，未设置时拒绝对端发起的流
+	SetOnStream(fn server.OnStreamFunc)
+	// Use 添加OnMessage中间件
+	Use(mw ...middleware.Middleware)
 	// GetConn 获取连接
 	GetConn(id uint32) (*conn.Conn, bool)
 	// GetAllConn 获取所有连接
@@ -27,17 +45,25 @@ type Server interface {
 	RangeConn(f func(conn *conn.Conn) bool)
 	// GetRouter 获取路由
 	GetRouter() router.Router
-	RequestTo(ctx context.Context, dst uint32, data []byte) (int16, []byte, error)
-	RequestTypeTo(ctx context.Context, typ uint8, dst uint32, data []byte) (int16, []byte, error)
+	RequestTo(ctx context.Context, dst uint32, data []byte, md ...message.Metadata) (int16, []byte, error)
+	RequestTypeTo(ctx context.Context, typ uint8, dst uint32, data []byte, md ...message.Metadata) (int16, []byte, error)
 	// RequestMessage 构建一个消息并发起请求，不要使用此方法发送消息，除非你知道自己在干什么，m的Id是Server内部维护的
 	RequestMessage(ctx context.Context, msg *message.Message) (int16, []byte, error)
-	SendTo(dst uint32, data []byte) error
-	SendTypeTo(typ uint8, dst uint32, data []byte) error
+	// RequestResponse 同RequestMessage，返回完整的响应消息，可用于获取响应的元数据
+	RequestResponse(ctx context.Context, msg *message.Message) (int16, *message.Message, error)
+	// OpenStreamTo 向dst节点发起流，跨桥接节点时经过路由转发
+	OpenStreamTo(ctx context.Context, dst uint32) (*stream.Stream, error)
+	SendTo(dst uint32, data []byte, md ...message.Metadata) error
+	SendTypeTo(typ uint8, dst uint32, data []byte, md ...message.Metadata) error
 	// SendMessage 构建一个消息并发送，不要使用此方法发送消息除非你知道自己在干什么，m的Id是Server内部维护的
 	SendMessage(m *message.Message) error
 	CreateMessageId() uint32
 	CreateMessage(typ uint8, src uint32, dst uint32, data []byte) *message.Message
 	RouteHop() uint8
+	// SrcIdViolations 源节点Id校验失败的消息数，见 server.SrcIdCheck
+	SrcIdViolations() uint64
+	// Shutdown 优雅关闭：停止接受新连接并通知所有连接，等待正在处理的消息完成后关闭，ctx结束时强制关闭
+	Shutdown(ctx context.Context) error
 	Close() error
 }
 
@@ -51,11 +77,42 @@ func NewServer(c *server.Config) Server {
 		ReaderBufSize:         c.ReaderBufSize,
 		WriterBufSize:         c.WriterBufSize,
 		MaxConnections:        c.MaxConnections,
+		MaxClientConnections:  c.MaxClientConnections,
+		MaxServerConnections:  c.MaxServerConnections,
+		BusyRetryAfter:        c.BusyRetryAfter,
 		SleepOnMaxConnections: c.SleepOnMaxConnections,
+		Takeover:              c.Takeover,
+		TakeoverIdle:          c.TakeoverIdle,
+		IdAllocator:           c.IdAllocator,
 		KeepaliveInterval:     c.KeepaliveInterval,
 		KeepaliveTimeout:      c.KeepaliveTimeout,
 		KeepaliveTimeoutClose: c.KeepaliveTimeoutClose,
 		MaxRouteHop:           c.MaxRouteHop,
+		DispatchMode:          c.DispatchMode,
+		DispatchWorkers:       c.DispatchWorkers,
+		DispatchQueueSize:     c.DispatchQueueSize,
+		DispatchOrdered:       c.DispatchOrdered,
+		PanicHandler:          c.PanicHandler,
+		CloseOnPanic:          c.CloseOnPanic,
+		HandshakeVersion:      c.HandshakeVersion,
+		Protocols:             c.Protocols,
+		Compressor:            c.Compressor,
+		CompressThreshold:     c.CompressThreshold,
+		Authenticator:         c.Authenticator,
+		Token:                 c.Token,
+		CertBinding:           c.CertBinding,
+		LegacyAuth:            c.LegacyAuth,
+		Integrity:             c.Integrity,
+		StreamWindow:          c.StreamWindow,
+		StreamIdleTimeout:     c.StreamIdleTimeout,
+		SrcIdCheck:            c.SrcIdCheck,
+		CloseOnSrcIdViolation: c.CloseOnSrcIdViolation,
+		MaxPendingHandshakes:  c.MaxPendingHandshakes,
+		HandshakeRate:         c.HandshakeRate,
+		HandshakeBurst:        c.HandshakeBurst,
+		DenyNets:              c.DenyNets,
+		AllowNets:             c.AllowNets,
+		ACL:                   c.ACL,
 	}
 }
 
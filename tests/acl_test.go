@@ -0,0 +1,95 @@
+package tests
+
+import (
+	"context"
+	"github.com/Li-giegie/node"
+	"github.com/Li-giegie/node/pkg/acl"
+	"github.com/Li-giegie/node/pkg/message"
+	"github.com/Li-giegie/node/pkg/protocol"
+	"github.com/Li-giegie/node/pkg/server"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestServerACL(t *testing.T) {
+	// 只允许10向11发送默认类型的消息
+	_, addr := startServer(t, 1, server.WithACL(acl.NewRules(false, acl.Rule{Src: []uint32{10}, Dst: []uint32{11}, Types: []uint8{message.MsgType_Default}, Allow: true})))
+	c10 := node.NewClientOption(10, 1)
+	c11 := node.NewClientOption(11, 1)
+	c11.AddOnMessage(echo)
+	for _, c := range []node.Client{c10, c11} {
+		if err := c.Connect(addr, nil); err != nil {
+			t.Fatal(err)
+		}
+		defer c.Close()
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	// 响应按请求的方向检查
+	if code, data, err := c10.RequestTo(ctx, 11, []byte("hello")); err != nil || code != message.StateCode_Success || string(data) != "hello" {
+		t.Fatal(code, err)
+	}
+	// 拒绝转发
+	if code, _, err := c11.RequestTo(ctx, 10, []byte("hello")); err != nil || code != message.StateCode_Forbidden {
+		t.Fatal(code, err)
+	}
+	// 拒绝本地处理
+	if code, _, err := c10.Request(ctx, []byte("hello")); err != nil || code != message.StateCode_Forbidden {
+		t.Fatal(code, err)
+	}
+	// 拒绝的单向消息直接丢弃，不回复
+	if err := c11.SendTo(10, []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	m := c11.CreateMessage(message.MsgType_Default, 11, 10, []byte("hello"))
+	m.NoReply = true
+	noReplyCtx, noReplyCancel := context.WithTimeout(context.Background(), time.Millisecond*200)
+	defer noReplyCancel()
+	if code, _, err := c11.RequestResponse(noReplyCtx, m); err == nil {
+		t.Fatal("denied one-way message replied", code)
+	}
+}
+
+// 默认拒绝时邻居节点之间的路由协议消息不检查
+func TestServerACLProtocol(t *testing.T) {
+	policy := acl.NewRules(false, acl.Rule{Src: []uint32{10, 20}, Dst: []uint32{10, 20}, Allow: true})
+	var servers []node.Server
+	var addrs []string
+	for _, id := range []uint32{1, 2} {
+		s, addr := startServer(t, id, server.WithACL(policy), server.WithProtocols(protocol.ProtocolType_RouteBFS))
+		p := protocol.NewRouterBFSProtocol(s)
+		s.AddOnConnect(p.OnConnect)
+		s.AddOnMessageWithType(protocol.ProtocolType_RouteBFS, p.OnMessage)
+		s.AddOnClose(p.OnClose)
+		servers = append(servers, s)
+		addrs = append(addrs, addr)
+	}
+	native, err := net.Dial("tcp", addrs[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = servers[0].Bridge(native, 2, nil); err != nil {
+		t.Fatal(err)
+	}
+	c10 := node.NewClientOption(10, 1)
+	c20 := node.NewClientOption(20, 2)
+	c20.AddOnMessage(echo)
+	if err = c10.Connect(addrs[0], nil); err != nil {
+		t.Fatal(err)
+	}
+	defer c10.Close()
+	if err = c20.Connect(addrs[1], nil); err != nil {
+		t.Fatal(err)
+	}
+	defer c20.Close()
+	waitFor(t, time.Second*2, func() bool {
+		_, ok := servers[0].GetRouter().GetRoute(20)
+		return ok
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if code, _, err := c10.RequestTo(ctx, 20, []byte("hello")); err != nil || code != message.StateCode_Success {
+		t.Fatal(code, err)
+	}
+}
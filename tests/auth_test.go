@@ -0,0 +1,120 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"github.com/Li-giegie/node"
+	"github.com/Li-giegie/node/pkg/auth"
+	"github.com/Li-giegie/node/pkg/client"
+	"github.com/Li-giegie/node/pkg/conn"
+	"github.com/Li-giegie/node/pkg/message"
+	"github.com/Li-giegie/node/pkg/server"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// tokenAuth 校验对端令牌的 Authenticator，不使用共享密钥
+func tokenAuth(token string) auth.Authenticator {
+	return auth.AuthenticatorFunc(func(info *auth.Info) (*conn.Identity, error) {
+		if !bytes.Equal(info.Credentials.Token, []byte(token)) {
+			return nil, errors.New("bad token")
+		}
+		return &conn.Identity{Subject: "node-" + string(rune('0'+info.RemoteId%10)), Attributes: map[string]string{"role": "admin"}}, nil
+	})
+}
+
+func TestServerAuthenticatorToken(t *testing.T) {
+	s, addr := startServer(t, 1, server.WithAuthenticator(tokenAuth("client-token")), server.WithToken([]byte("server-token")))
+	s.AddOnMessage(echo)
+	c := node.NewClientOption(10, 1, client.WithAuthenticator(tokenAuth("server-token")), client.WithToken([]byte("client-token")))
+	if err := c.Connect(addr, nil); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	cn, ok := s.GetConn(10)
+	if !ok {
+		t.Fatal("client not connected")
+	}
+	if id := cn.Identity(); id == nil || id.Subject != "node-0" || id.Get("role") != "admin" {
+		t.Fatalf("server identity = %+v", id)
+	}
+	if id := c.Conn().Identity(); id == nil || id.Subject != "node-1" {
+		t.Fatalf("client identity = %+v", id)
+	}
+	if code, _, err := c.Request(context.Background(), []byte("hello")); err != nil || code != message.StateCode_Success {
+		t.Fatal(code, err)
+	}
+}
+
+func TestServerAuthenticatorReject(t *testing.T) {
+	_, addr := startServer(t, 1, server.WithAuthenticator(tokenAuth("client-token")))
+	c := node.NewClientOption(10, 1, client.WithAuthenticator(auth.AuthenticatorFunc(func(*auth.Info) (*conn.Identity, error) { return nil, nil })), client.WithToken([]byte("wrong")))
+	err := c.Connect(addr, nil)
+	if err == nil {
+		_ = c.Close()
+		t.Fatal("expected rejection")
+	}
+	// 拒绝原因发送给对端
+	if !strings.Contains(err.Error(), "bad token") {
+		t.Fatalf("err = %v", err)
+	}
+}
+
+func TestClientStartAuthenticator(t *testing.T) {
+	_, addr := startServer(t, 1, server.WithAuthenticator(tokenAuth("client-token")), server.WithToken([]byte("server-token")))
+	for _, tc := range []struct {
+		serverToken string
+		ok          bool
+	}{{"server-token", true}, {"other", false}} {
+		native, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c := node.NewClientOption(10, 1, client.WithAuthenticator(tokenAuth(tc.serverToken)), client.WithToken([]byte("client-token")))
+		err = c.Start(native, nil)
+		if tc.ok != (err == nil) {
+			t.Fatalf("server token %q: err = %v", tc.serverToken, err)
+		}
+		if err == nil {
+			_ = c.Close()
+		}
+	}
+}
+
+// 使用不基于共享密钥的 Authenticator 桥接时不应发起挑战应答认证
+func TestServerBridgeAuthenticator(t *testing.T) {
+	s1, _ := startServer(t, 1, server.WithAuthenticator(tokenAuth("token-2")), server.WithToken([]byte("token-1")))
+	s2, addr2 := startServer(t, 2, server.WithAuthenticator(tokenAuth("token-1")), server.WithToken([]byte("token-2")))
+	native, err := net.Dial("tcp", addr2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = s1.Bridge(native, 2, nil); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, time.Second, func() bool {
+		_, ok := s2.GetConn(1)
+		return ok
+	})
+	cn, _ := s1.GetConn(2)
+	if id := cn.Identity(); id == nil || id.Subject != "node-2" {
+		t.Fatalf("identity = %+v", id)
+	}
+}
+
+// 未指定对端密钥且 Authenticator 不使用共享密钥时不应以空密钥通过对端的密钥认证
+func TestServerBridgeNoKey(t *testing.T) {
+	s1, _ := startServer(t, 1, server.WithAuthenticator(tokenAuth("")))
+	_, addr2 := startServer(t, 2)
+	native, err := net.Dial("tcp", addr2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s1.Bridge(native, 2, nil)
+	if err == nil || !strings.Contains(err.Error(), "key authentication required") {
+		t.Fatalf("err = %v", err)
+	}
+}
@@ -0,0 +1,84 @@
+package tests
+
+import (
+	stderrors "errors"
+	"github.com/Li-giegie/node"
+	"github.com/Li-giegie/node/pkg/errors"
+	"github.com/Li-giegie/node/pkg/server"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestServerMaxConnections(t *testing.T) {
+	_, addr1 := startServer(t, 1, server.WithMaxConnections(1), server.WithBusyRetryAfter(time.Millisecond*500))
+	_, addr2 := startServer(t, 1, server.WithMaxConnections(1), server.WithBusyRetryAfter(time.Second*2))
+	for i, addr := range []string{addr1, addr2} {
+		c := node.NewClientOption(uint32(10+i), 1)
+		if err := c.Connect(addr, nil); err != nil {
+			t.Fatal(err)
+		}
+		defer c.Close()
+	}
+	c := node.NewClientOption(20, 1)
+	err := c.ConnectAddrs([]string{addr1, addr2}, nil)
+	if err == nil {
+		_ = c.Close()
+		t.Fatal("connected to a full server")
+	}
+	// 全部繁忙时返回建议重试间隔最短的错误
+	var busy *errors.ServerBusyError
+	if !stderrors.As(err, &busy) || busy.RetryAfter != time.Millisecond*500 {
+		t.Fatalf("err = %v", err)
+	}
+	// 存在不可用的地址时返回最后一次失败的原因
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = closed.Close()
+	if err = c.ConnectAddrs([]string{addr1, closed.Addr().String()}, nil); err == nil || stderrors.As(err, &busy) {
+		t.Fatalf("err = %v", err)
+	}
+}
+
+func TestServerConnectionLimits(t *testing.T) {
+	s1, addr1 := startServer(t, 1, server.WithConnectionLimits(1, 1))
+	c := node.NewClientOption(10, 1)
+	if err := c.Connect(addr1, nil); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	c11 := node.NewClientOption(11, 1)
+	err := c11.Connect(addr1, nil)
+	if err == nil {
+		_ = c11.Close()
+		t.Fatal("client limit exceeded")
+	}
+	var busy *errors.ServerBusyError
+	if !stderrors.As(err, &busy) {
+		t.Fatalf("err = %v", err)
+	}
+	// 客户端连接已满时桥接节点仍可接入，服务端类型的连接单独限制
+	for _, id := range []uint32{2, 3} {
+		s, _ := startServer(t, id)
+		native, err := net.Dial("tcp", addr1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = s.Bridge(native, 1, nil)
+		if id == 2 && err != nil {
+			t.Fatal(err)
+		}
+		if id == 3 && !stderrors.As(err, &busy) {
+			t.Fatalf("bridge err = %v", err)
+		}
+	}
+	waitFor(t, time.Second, func() bool {
+		_, ok := s1.GetConn(2)
+		return ok
+	})
+	if _, ok := s1.GetConn(3); ok {
+		t.Fatal("server limit exceeded")
+	}
+}
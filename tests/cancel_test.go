@@ -0,0 +1,107 @@
+package tests
+
+import (
+	"context"
+	"github.com/Li-giegie/node"
+	"github.com/Li-giegie/node/pkg/dispatch"
+	"github.com/Li-giegie/node/pkg/message"
+	"github.com/Li-giegie/node/pkg/protocol"
+	"github.com/Li-giegie/node/pkg/reply"
+	"github.com/Li-giegie/node/pkg/server"
+	"net"
+	"testing"
+	"time"
+)
+
+// 取消请求后服务端处理器的上下文结束，内联分发时处理期间收不到取消消息，只测试并发的分发模式
+func TestRequestCancel(t *testing.T) {
+	for _, mode := range []dispatch.Mode{dispatch.ModeGoroutine, dispatch.ModePool} {
+		t.Run(mode.String(), func(t *testing.T) {
+			s, addr := startServer(t, 1, server.WithDispatch(mode, 2, 4, false))
+			started := make(chan struct{})
+			done := make(chan error, 1)
+			s.AddOnMessage(func(r *reply.Reply, m *message.Message) bool {
+				close(started)
+				select {
+				case <-r.Context().Done():
+					done <- r.Context().Err()
+				case <-time.After(time.Second * 2):
+					done <- nil
+				}
+				return true
+			})
+			c := node.NewClientOption(10, 1)
+			if err := c.Connect(addr, nil); err != nil {
+				t.Fatal(err)
+			}
+			defer c.Close()
+			ctx, cancel := context.WithCancel(context.Background())
+			go func() {
+				<-started
+				cancel()
+			}()
+			if _, _, err := c.Request(ctx, []byte("x")); err == nil {
+				t.Fatal("cancelled request returned no error")
+			}
+			if err := <-done; err != context.Canceled {
+				t.Fatalf("handler context err = %v, want %v", err, context.Canceled)
+			}
+		})
+	}
+}
+
+// 截止时间经过桥接节点转发后剩余时间只减不增
+func TestRequestDeadlineBridge(t *testing.T) {
+	var servers []node.Server
+	var addrs []string
+	for _, id := range []uint32{1, 2} {
+		s, addr := startServer(t, id, server.WithProtocols(protocol.ProtocolType_RouteBFS))
+		p := protocol.NewRouterBFSProtocol(s)
+		s.AddOnConnect(p.OnConnect)
+		s.AddOnMessageWithType(protocol.ProtocolType_RouteBFS, p.OnMessage)
+		s.AddOnClose(p.OnClose)
+		servers = append(servers, s)
+		addrs = append(addrs, addr)
+	}
+	native, err := net.Dial("tcp", addrs[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = servers[0].Bridge(native, 2, nil); err != nil {
+		t.Fatal(err)
+	}
+	remainC := make(chan time.Duration, 1)
+	c10 := node.NewClientOption(10, 1)
+	c20 := node.NewClientOption(20, 2)
+	c20.AddOnMessage(func(r *reply.Reply, m *message.Message) bool {
+		d, ok := r.Context().Deadline()
+		if !ok {
+			remainC <- 0
+		} else {
+			remainC <- time.Until(d)
+		}
+		_ = r.Write(message.StateCode_Success, nil)
+		return true
+	})
+	if err = c10.Connect(addrs[0], nil); err != nil {
+		t.Fatal(err)
+	}
+	defer c10.Close()
+	if err = c20.Connect(addrs[1], nil); err != nil {
+		t.Fatal(err)
+	}
+	defer c20.Close()
+	waitFor(t, time.Second*2, func() bool {
+		_, ok := servers[0].GetRouter().GetRoute(20)
+		return ok
+	})
+	const budget = time.Second
+	ctx, cancel := context.WithTimeout(context.Background(), budget)
+	defer cancel()
+	if code, _, err := c10.RequestTo(ctx, 20, []byte("x")); err != nil || code != message.StateCode_Success {
+		t.Fatal(code, err)
+	}
+	if remain := <-remainC; remain <= 0 || remain >= budget {
+		t.Fatalf("remaining budget = %v, want (0, %v)", remain, budget)
+	}
+}
@@ -0,0 +1,144 @@
+package tests
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"github.com/Li-giegie/node"
+	"github.com/Li-giegie/node/pkg/auth"
+	"github.com/Li-giegie/node/pkg/errors"
+	"github.com/Li-giegie/node/pkg/server"
+	"math/big"
+	"net"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// issueCert 签发证书，parent为nil时自签名
+func issueCert(t *testing.T, serial int64, uri string, isCA bool, parent *tls.Certificate) tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(serial),
+		Subject:               pkix.Name{CommonName: uri},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  isCA,
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1)},
+	}
+	if uri != "" {
+		u, err := url.Parse(uri)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tmpl.URIs = []*url.URL{u}
+	}
+	signer, signerKey := tmpl, any(key)
+	if parent != nil {
+		signer, signerKey = parent.Leaf, parent.PrivateKey
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}
+}
+
+func TestServerCertBinding(t *testing.T) {
+	ca := issueCert(t, 1, "", true, nil)
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.Leaf)
+	serverCert := issueCert(t, 2, "node://1", false, &ca)
+	for _, tc := range []struct {
+		name       string
+		clientAuth tls.ClientAuthType
+		cert       tls.Certificate
+		err        string
+	}{
+		{"verified", tls.RequireAndVerifyClientCert, issueCert(t, 3, "node://10", false, &ca), ""},
+		{"mismatch", tls.RequireAndVerifyClientCert, issueCert(t, 4, "node://11", false, &ca), errors.ErrCertMismatch.Error()},
+		// 服务端不验证客户端证书时，自签名证书不能用于绑定节点Id
+		{"unverified", tls.RequireAnyClientCert, issueCert(t, 5, "node://10", false, nil), errors.ErrCertRequired.Error()},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			l, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			l = tls.NewListener(l, &tls.Config{Certificates: []tls.Certificate{serverCert}, ClientCAs: pool, ClientAuth: tc.clientAuth})
+			s, addr := serveListener(t, 1, l, server.WithCertBinding(auth.CertURIBinding("node")))
+			c := node.NewClientOption(10, 1)
+			err = c.Connect(addr, nil, &tls.Config{RootCAs: pool, GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+				// 总是发送证书，即使不是服务端接受的CA签发的
+				return &tc.cert, nil
+			}})
+			if tc.err == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer c.Close()
+				if _, ok := s.GetConn(10); !ok {
+					t.Fatal("client not connected")
+				}
+				return
+			}
+			if err == nil {
+				_ = c.Close()
+				t.Fatal("expected rejection")
+			}
+			if !strings.Contains(err.Error(), tc.err) {
+				t.Fatalf("err = %v", err)
+			}
+			if _, ok := s.GetConn(10); ok {
+				t.Fatal("rejected client connected")
+			}
+		})
+	}
+}
+
+// 服务端分配节点Id时证书与分配的节点Id绑定，拒绝后回收分配的节点Id
+func TestServerCertBindingAllocatedId(t *testing.T) {
+	ca := issueCert(t, 1, "", true, nil)
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.Leaf)
+	serverCert := issueCert(t, 2, "node://1", false, &ca)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l = tls.NewListener(l, &tls.Config{Certificates: []tls.Certificate{serverCert}, ClientCAs: pool, ClientAuth: tls.RequireAndVerifyClientCert})
+	s, addr := serveListener(t, 1, l, server.WithCertBinding(auth.CertURIBinding("node")), server.WithIdAllocator(server.NewRangeAllocator(100, 100)))
+	connect := func(cert tls.Certificate) (node.Client, error) {
+		c := node.NewClientOption(0, 1)
+		return c, c.Connect(addr, nil, &tls.Config{RootCAs: pool, Certificates: []tls.Certificate{cert}})
+	}
+	if _, err = connect(issueCert(t, 3, "node://101", false, &ca)); err == nil || !strings.Contains(err.Error(), errors.ErrCertMismatch.Error()) {
+		t.Fatalf("err = %v, want %v", err, errors.ErrCertMismatch)
+	}
+	c, err := connect(issueCert(t, 4, "node://100", false, &ca))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if c.NodeId() != 100 {
+		t.Fatalf("node id = %d, want 100", c.NodeId())
+	}
+	if _, ok := s.GetConn(100); !ok {
+		t.Fatal("client not connected")
+	}
+}
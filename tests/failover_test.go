@@ -0,0 +1,68 @@
+package tests
+
+import (
+	"github.com/Li-giegie/node"
+	"github.com/Li-giegie/node/pkg/client"
+	"github.com/Li-giegie/node/pkg/server"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestClientFailover(t *testing.T) {
+	key := []byte("key")
+	// 接受连接但从不响应握手的地址
+	silent, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer silent.Close()
+	go func() {
+		for {
+			c, err := silent.Accept()
+			if err != nil {
+				return
+			}
+			defer c.Close()
+		}
+	}()
+	// 已关闭的地址
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = closed.Close()
+	s1, addr1 := startServer(t, 1, server.WithAuthKey(key))
+	s2, addr2 := startServer(t, 1, server.WithAuthKey(key))
+	c := node.NewClientOption(10, 1, client.WithRemoteKey(key), client.WithDialTimeout(time.Millisecond*200), client.WithAuthTimeout(time.Millisecond*200))
+	start := time.Now()
+	if err = c.ConnectAddrs([]string{closed.Addr().String(), silent.Addr().String(), addr1, addr2}, nil); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("connect took %v", d)
+	}
+	if _, ok := s1.GetConn(10); !ok {
+		t.Fatal("not connected to the first available address")
+	}
+	// 当前地址断开后切换到下一个地址
+	_ = s1.Close()
+	// 服务端添加连接时客户端可能尚未收到握手响应
+	waitFor(t, time.Second*2, func() bool {
+		_, ok := s2.GetConn(10)
+		return ok && c.State() == client.StateRunning
+	})
+}
+
+func TestClientConnectAddrsAllFailed(t *testing.T) {
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = closed.Close()
+	c := node.NewClientOption(10, 1, client.WithDialTimeout(time.Millisecond*200))
+	if err = c.ConnectAddrs([]string{closed.Addr().String(), closed.Addr().String()}, nil); err == nil {
+		t.Fatal("connected to a closed address")
+	}
+}
@@ -0,0 +1,136 @@
+package tests
+
+import (
+	"context"
+	"github.com/Li-giegie/node"
+	"github.com/Li-giegie/node/pkg/client"
+	"github.com/Li-giegie/node/pkg/message"
+	"github.com/Li-giegie/node/pkg/middleware"
+	"github.com/Li-giegie/node/pkg/reply"
+	"github.com/Li-giegie/node/pkg/server"
+	"sync/atomic"
+	"testing"
+)
+
+const (
+	typInstance uint8 = 200
+	typDefault  uint8 = 201
+)
+
+func TestServerHandlerIsolation(t *testing.T) {
+	server.OnMessageType(typDefault, func(r *reply.Reply, m *message.Message) bool {
+		_ = r.Write(message.StateCode_Success, []byte("default"))
+		return true
+	})
+	t.Cleanup(func() { server.Default.SetOnMessageWithType(typDefault) })
+	s1, addr1 := startServer(t, 1)
+	_, addr2 := startServer(t, 2)
+	s1.AddOnMessageWithType(typInstance, echo)
+	s1.AddOnMessageWithType(typDefault, echo)
+	if !s1.RemoveOnMessageWithType(typDefault) {
+		t.Fatal("remove registered type returned false")
+	}
+	c1 := node.NewClientOption(10, 1)
+	c2 := node.NewClientOption(10, 2)
+	for c, addr := range map[node.Client]string{c1: addr1, c2: addr2} {
+		if err := c.Connect(addr, nil); err != nil {
+			t.Fatal(err)
+		}
+		defer c.Close()
+	}
+	check := func(c node.Client, typ uint8, want int16) {
+		t.Helper()
+		code, _, err := c.RequestType(context.Background(), typ, []byte("x"))
+		if err != nil || code != want {
+			t.Fatalf("type %d: code = %d, err = %v, want %d", typ, code, err, want)
+		}
+	}
+	// 实例上的处理器互不影响
+	check(c1, typInstance, message.StateCode_Success)
+	check(c2, typInstance, message.StateCode_MessageTypeInvalid)
+	// 移除的类型不回退到全局处理器，其他实例仍使用全局处理器
+	check(c1, typDefault, message.StateCode_MessageTypeInvalid)
+	check(c2, typDefault, message.StateCode_Success)
+}
+
+func TestClientHandlerIsolation(t *testing.T) {
+	client.OnMessageType(typDefault, func(r *reply.Reply, m *message.Message) bool {
+		_ = r.Write(message.StateCode_Success, []byte("default"))
+		return true
+	})
+	t.Cleanup(func() { client.Default.SetOnMessageWithType(typDefault) })
+	s, addr := startServer(t, 1)
+	c1 := node.NewClientOption(10, 1)
+	c2 := node.NewClientOption(11, 1)
+	c1.AddOnMessageWithType(typInstance, echo)
+	c1.AddOnMessageWithType(typDefault, echo)
+	if !c1.RemoveOnMessageWithType(typDefault) {
+		t.Fatal("remove registered type returned false")
+	}
+	for _, c := range []node.Client{c1, c2} {
+		if err := c.Connect(addr, nil); err != nil {
+			t.Fatal(err)
+		}
+		defer c.Close()
+	}
+	check := func(id uint32, typ uint8, want int16) {
+		t.Helper()
+		cn, ok := s.GetConn(id)
+		if !ok {
+			t.Fatalf("client %d not connected", id)
+		}
+		code, _, err := cn.RequestType(context.Background(), typ, []byte("x"))
+		if err != nil || code != want {
+			t.Fatalf("client %d type %d: code = %d, err = %v, want %d", id, typ, code, err, want)
+		}
+	}
+	check(10, typInstance, message.StateCode_Success)
+	check(11, typInstance, message.StateCode_MessageTypeInvalid)
+	check(10, typDefault, message.StateCode_MessageTypeInvalid)
+	check(11, typDefault, message.StateCode_Success)
+}
+
+func TestServerMiddlewareFallback(t *testing.T) {
+	const typ uint8 = 202
+	var global, local int64
+	count := func(n *int64) middleware.Middleware {
+		return func(next middleware.HandlerFunc) middleware.HandlerFunc {
+			return func(r *reply.Reply, m *message.Message) bool {
+				if m.Type == typ {
+					atomic.AddInt64(n, 1)
+				}
+				return next(r, m)
+			}
+		}
+	}
+	server.OnMessageType(typ, echo)
+	server.Use(count(&global))
+	t.Cleanup(func() {
+		server.Default.SetOnMessageWithType(typ)
+	})
+	s1, addr1 := startServer(t, 1)
+	_, addr2 := startServer(t, 2)
+	s1.Use(count(&local))
+	c1 := node.NewClientOption(10, 1)
+	c2 := node.NewClientOption(10, 2)
+	for c, addr := range map[node.Client]string{c1: addr1, c2: addr2} {
+		if err := c.Connect(addr, nil); err != nil {
+			t.Fatal(err)
+		}
+		defer c.Close()
+	}
+	// 实例添加了中间件时，回退到全局处理器的消息只经过实例的中间件
+	if code, _, err := c1.RequestType(context.Background(), typ, nil); err != nil || code != message.StateCode_Success {
+		t.Fatal(code, err)
+	}
+	if atomic.LoadInt64(&global) != 0 || atomic.LoadInt64(&local) != 1 {
+		t.Fatalf("global = %d, local = %d", global, local)
+	}
+	// 实例未添加中间件时使用全局中间件
+	if code, _, err := c2.RequestType(context.Background(), typ, nil); err != nil || code != message.StateCode_Success {
+		t.Fatal(code, err)
+	}
+	if atomic.LoadInt64(&global) != 1 || atomic.LoadInt64(&local) != 1 {
+		t.Fatalf("global = %d, local = %d", global, local)
+	}
+}
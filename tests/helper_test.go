@@ -0,0 +1,63 @@
+package tests
+
+import (
+	"github.com/Li-giegie/node"
+	"github.com/Li-giegie/node/pkg/message"
+	"github.com/Li-giegie/node/pkg/reply"
+	"github.com/Li-giegie/node/pkg/server"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// startServer 在随机端口上启动使用实例处理器的服务端，测试结束时关闭
+func startServer(t *testing.T, id uint32, opts ...server.Option) (node.Server, string) {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return serveListener(t, id, l, opts...)
+}
+
+// serveListener 在l上启动使用实例处理器的服务端，测试结束时关闭
+func serveListener(t *testing.T, id uint32, l net.Listener, opts ...server.Option) (node.Server, string) {
+	t.Helper()
+	s := node.NewServerOption(id, opts...)
+	rl := &readyListener{Listener: l, ready: make(chan struct{})}
+	go s.Serve(rl, nil)
+	// Serve 初始化完成后才开始Accept，之后测试中可以直接调用Bridge等方法
+	<-rl.ready
+	t.Cleanup(func() { _ = s.Close() })
+	return s, l.Addr().String()
+}
+
+// readyListener 第一次调用Accept时关闭ready
+type readyListener struct {
+	net.Listener
+	once  sync.Once
+	ready chan struct{}
+}
+
+func (l *readyListener) Accept() (net.Conn, error) {
+	l.once.Do(func() { close(l.ready) })
+	return l.Listener.Accept()
+}
+
+func echo(r *reply.Reply, m *message.Message) bool {
+	_ = r.Write(message.StateCode_Success, m.Data)
+	return true
+}
+
+// waitFor 轮询f直至返回true，超时时测试失败
+func waitFor(t *testing.T, timeout time.Duration, f func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(timeout)
+	for !f() {
+		if time.Now().After(deadline) {
+			t.Fatal("wait timeout")
+		}
+		time.Sleep(time.Millisecond * 5)
+	}
+}
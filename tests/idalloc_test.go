@@ -0,0 +1,67 @@
+package tests
+
+import (
+	"context"
+	"github.com/Li-giegie/node"
+	"github.com/Li-giegie/node/pkg/client"
+	"github.com/Li-giegie/node/pkg/conn"
+	"github.com/Li-giegie/node/pkg/message"
+	"github.com/Li-giegie/node/pkg/server"
+	"testing"
+	"time"
+)
+
+func TestClientIdAllocation(t *testing.T) {
+	s, addr := startServer(t, 1, server.WithIdAllocator(server.NewRangeAllocator(100, 199)))
+	s.AddOnMessage(echo)
+	c := node.NewClientOption(0, 1, client.WithReconnect(true), client.WithReconnectBackoff(time.Millisecond*10, time.Millisecond*50, 2, 0))
+	reconnected := make(chan *conn.Conn, 1)
+	c.AddOnReconnected(func(cn *conn.Conn) bool {
+		reconnected <- cn
+		return true
+	})
+	if err := c.Connect(addr, nil); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	id := c.NodeId()
+	if id < 100 || id > 199 {
+		t.Fatalf("node id = %d", id)
+	}
+	if _, ok := s.GetConn(id); !ok {
+		t.Fatal("allocated id not connected")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*2)
+	defer cancel()
+	if code, _, err := c.Request(ctx, []byte("hello")); err != nil || code != message.StateCode_Success {
+		t.Fatal(code, err)
+	}
+	// 重连时重新分配，期间并发读取节点Id
+	ok := make(chan bool, 1)
+	go func() {
+		for deadline := time.Now().Add(time.Second * 2); time.Now().Before(deadline); {
+			if c.NodeId() == 0 {
+				break
+			}
+			select {
+			case <-reconnected:
+				ok <- true
+				return
+			default:
+			}
+		}
+		ok <- false
+	}()
+	cn, _ := s.GetConn(id)
+	_ = cn.Close()
+	if !<-ok {
+		t.Fatal("not reconnected")
+	}
+	id = c.NodeId()
+	if _, ok := s.GetConn(id); !ok || id < 100 || id > 199 {
+		t.Fatalf("node id after reconnect = %d", id)
+	}
+	if code, _, err := c.Request(ctx, []byte("hello")); err != nil || code != message.StateCode_Success {
+		t.Fatal(code, err)
+	}
+}
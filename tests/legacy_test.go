@@ -0,0 +1,76 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"github.com/Li-giegie/node/internal"
+	"github.com/Li-giegie/node/pkg/conn"
+	"github.com/Li-giegie/node/pkg/message"
+	"github.com/Li-giegie/node/pkg/server"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// legacyFrame 按旧版本的格式编码消息：20Byte消息头，没有Flags和CRC
+func legacyFrame(typ uint8, id, src, dst uint32, data []byte) []byte {
+	b := make([]byte, message.MsgHeaderLen+len(data))
+	b[0] = typ
+	binary.LittleEndian.PutUint32(b[2:6], id)
+	binary.LittleEndian.PutUint32(b[6:10], src)
+	binary.LittleEndian.PutUint32(b[10:14], dst)
+	binary.LittleEndian.PutUint32(b[14:18], uint32(len(data)))
+	var sum uint16
+	for _, v := range b[:18] {
+		sum += uint16(v)
+	}
+	binary.LittleEndian.PutUint16(b[18:20], sum)
+	copy(b[20:], data)
+	return b
+}
+
+// 旧版本客户端使用v1握手及20Byte消息头，服务端默认启用CRC32-C时也不能向其发送新格式
+func TestServerV1WireFormat(t *testing.T) {
+	key := []byte("key")
+	s, addr := startServer(t, 1, server.WithAuthKey(key), server.WithLegacyAuth(true), server.WithIntegrity(conn.IntegrityCRC32C))
+	s.AddOnMessage(echo)
+	native, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer native.Close()
+	req := append([]byte{byte(conn.TypeClient), 10, 0, 0, 0, 1, 0, 0, 0}, internal.Hash(key)...)
+	if _, err = native.Write(req); err != nil {
+		t.Fatal(err)
+	}
+	_ = native.SetReadDeadline(time.Now().Add(time.Second))
+	resp := make([]byte, 22)
+	if _, err = io.ReadFull(native, resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp[0] != byte(conn.TypeServer) || resp[1] != byte(internal.BaseAuthResponseCodeSuccess) {
+		t.Fatalf("invalid handshake response %x", resp)
+	}
+	if _, err = native.Write(legacyFrame(message.MsgType_Default, 7, 10, 1, []byte("hello"))); err != nil {
+		t.Fatal(err)
+	}
+	want := legacyFrame(message.MsgType_Response, 7, 1, 10, append([]byte{byte(message.StateCode_Success), 0}, "hello"...))
+	got := make([]byte, len(want))
+	if _, err = io.ReadFull(native, got); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Fatalf("response = %x, want %x", got, want)
+	}
+	// 没有CRC尾部，Shutdown时也不发送v1不支持的GoAway
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err = s.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := native.Read(got); n != 0 || err != io.EOF {
+		t.Fatalf("unexpected data %x, %v", got[:n], err)
+	}
+}
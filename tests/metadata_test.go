@@ -0,0 +1,108 @@
+package tests
+
+import (
+	"github.com/Li-giegie/node"
+	"github.com/Li-giegie/node/internal"
+	"github.com/Li-giegie/node/pkg/client"
+	"github.com/Li-giegie/node/pkg/conn"
+	"github.com/Li-giegie/node/pkg/errors"
+	"github.com/Li-giegie/node/pkg/server"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestServerHandshakeMetadata(t *testing.T) {
+	s, addr := startServer(t, 1)
+	c := node.NewClientOption(10, 1, client.WithHandshakeMetadata(map[string]string{"version": "2.1", "tenant": "fake"}))
+	if err := c.Connect(addr, nil); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	cn, ok := s.GetConn(10)
+	if !ok {
+		t.Fatal("client not connected")
+	}
+	if md := cn.PeerMetadata(); md["version"] != "2.1" || md["tenant"] != "fake" {
+		t.Fatalf("peer metadata = %v", md)
+	}
+	// 对端提供的元数据不能伪造会话属性
+	if _, ok = cn.Attr("tenant"); ok {
+		t.Fatal("peer metadata merged into attrs")
+	}
+}
+
+// 服务端拒绝超过 internal.HandshakeMetadataMaxLen 的元数据，不依赖客户端的检查
+func TestServerHandshakeMetadataTooLarge(t *testing.T) {
+	s, addr := startServer(t, 1)
+	native, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer native.Close()
+	md := make(map[string]string)
+	for _, k := range []string{"a", "b", "c", "d", "e"} {
+		md[k] = strings.Repeat("x", 1000)
+	}
+	req := &internal.BaseAuthRequest{
+		ConnType: conn.TypeClient,
+		SrcId:    10,
+		DstId:    1,
+		Version:  internal.HandshakeV2,
+		Features: conn.Features,
+		Metadata: md,
+	}
+	resp, _, err := internal.DefaultAuthService.Handshake(native, req, nil, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = resp.Err(); err == nil || !strings.Contains(err.Error(), errors.ErrLengthOverflow.Error()) {
+		t.Fatalf("err = %v", err)
+	}
+	if _, ok := s.GetConn(10); ok {
+		t.Fatal("client connected")
+	}
+}
+
+// 客户端和桥接时不发送超过 internal.HandshakeTokenMaxLen 的凭据，服务端同样拒绝
+func TestHandshakeTokenTooLarge(t *testing.T) {
+	token := make([]byte, internal.HandshakeTokenMaxLen+1)
+	s, addr := startServer(t, 1)
+	c := node.NewClientOption(10, 1, client.WithToken(token))
+	if err := c.Connect(addr, nil); err == nil || err.Error() != errors.ErrLengthOverflow.Error() {
+		t.Fatalf("client err = %v, want %v", err, errors.ErrLengthOverflow)
+	}
+	s2, _ := startServer(t, 2, server.WithToken(token))
+	native, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer native.Close()
+	if err = s2.Bridge(native, 1, nil); err == nil || err.Error() != errors.ErrLengthOverflow.Error() {
+		t.Fatalf("bridge err = %v, want %v", err, errors.ErrLengthOverflow)
+	}
+	native, err = net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer native.Close()
+	req := &internal.BaseAuthRequest{
+		ConnType: conn.TypeClient,
+		SrcId:    10,
+		DstId:    1,
+		Version:  internal.HandshakeV2,
+		Features: conn.Features,
+		Token:    token,
+	}
+	resp, _, err := internal.DefaultAuthService.Handshake(native, req, nil, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = resp.Err(); err == nil || !strings.Contains(err.Error(), errors.ErrLengthOverflow.Error()) {
+		t.Fatalf("err = %v", err)
+	}
+	if _, ok := s.GetConn(10); ok {
+		t.Fatal("client connected")
+	}
+}
@@ -0,0 +1,89 @@
+package tests
+
+import (
+	"context"
+	"github.com/Li-giegie/node"
+	"github.com/Li-giegie/node/pkg/client"
+	"github.com/Li-giegie/node/pkg/conn"
+	"github.com/Li-giegie/node/pkg/message"
+	"github.com/Li-giegie/node/pkg/middleware"
+	"github.com/Li-giegie/node/pkg/reply"
+	"github.com/Li-giegie/node/pkg/server"
+	"testing"
+	"time"
+)
+
+func panicHandler(r *reply.Reply, m *message.Message) bool {
+	if string(m.Data) == "panic" {
+		panic("boom")
+	}
+	return echo(r, m)
+}
+
+func TestServerPanicRecovery(t *testing.T) {
+	panics := make(chan any, 1)
+	s, addr := startServer(t, 1, server.WithPanicHandler(func(c *conn.Conn, m *message.Message, v any, stack []byte) {
+		panics <- v
+	}, false))
+	s.AddOnMessage(panicHandler)
+	c := node.NewClientOption(10, 1)
+	if err := c.Connect(addr, nil); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	code, _, err := c.Request(context.Background(), []byte("panic"))
+	if err != nil || code != message.StateCode_InternalError {
+		t.Fatal(code, err)
+	}
+	select {
+	case v := <-panics:
+		if v != "boom" {
+			t.Fatalf("panic value = %v", v)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("panic handler not called")
+	}
+	// 未设置closeConn时连接可继续使用
+	if code, _, err = c.Request(context.Background(), []byte("ok")); err != nil || code != message.StateCode_Success {
+		t.Fatal(code, err)
+	}
+}
+
+func TestServerPanicClose(t *testing.T) {
+	s, addr := startServer(t, 1, server.WithPanicHandler(func(c *conn.Conn, m *message.Message, v any, stack []byte) {}, true))
+	s.AddOnMessage(panicHandler)
+	c := node.NewClientOption(10, 1)
+	if err := c.Connect(addr, nil); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	code, _, _ := c.Request(context.Background(), []byte("panic"))
+	if code != message.StateCode_InternalError {
+		t.Fatalf("code = %d", code)
+	}
+	waitFor(t, time.Second, func() bool { return c.State() == client.StateClosed })
+}
+
+func TestRecoveryMiddleware(t *testing.T) {
+	s, addr := startServer(t, 1)
+	recovered := make(chan any, 1)
+	s.Use(middleware.Recovery(func(m *message.Message, v any, stack []byte) { recovered <- v }))
+	s.AddOnMessage(panicHandler)
+	c := node.NewClientOption(10, 1)
+	if err := c.Connect(addr, nil); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	code, _, err := c.Request(context.Background(), []byte("panic"))
+	if err != nil || code != message.StateCode_InternalError {
+		t.Fatal(code, err)
+	}
+	select {
+	case v := <-recovered:
+		if v != "boom" {
+			t.Fatalf("recovered = %v", v)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("recovery callback not called")
+	}
+}
@@ -0,0 +1,130 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"github.com/Li-giegie/node"
+	"github.com/Li-giegie/node/pkg/client"
+	"github.com/Li-giegie/node/pkg/conn"
+	"github.com/Li-giegie/node/pkg/errors"
+	"github.com/Li-giegie/node/pkg/message"
+	"github.com/Li-giegie/node/pkg/server"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestClientReconnect(t *testing.T) {
+	key := []byte("key")
+	s, addr := startServer(t, 1, server.WithAuthKey(key))
+	s.AddOnMessage(echo)
+	c := node.NewClientOption(10, 1, client.WithRemoteKey(key), client.WithReconnect(true), client.WithReconnectBackoff(time.Millisecond*10, time.Millisecond*50, 2, 0))
+	var l sync.Mutex
+	var attempts []int
+	reconnected := make(chan *conn.Conn, 1)
+	c.AddOnReconnecting(func(attempt int, err error) bool {
+		l.Lock()
+		attempts = append(attempts, attempt)
+		l.Unlock()
+		return true
+	})
+	c.AddOnReconnected(func(cn *conn.Conn) bool {
+		reconnected <- cn
+		return true
+	})
+	if err := c.Connect(addr, nil); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	// 重连期间并发请求，连接的替换不应产生数据竞争
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for ctx.Err() == nil {
+				rctx, rcancel := context.WithTimeout(ctx, time.Millisecond*100)
+				_, _, _ = c.Request(rctx, []byte("ping"))
+				rcancel()
+			}
+		}()
+	}
+	old, ok := s.GetConn(10)
+	if !ok {
+		t.Fatal("client not connected")
+	}
+	_ = old.Close()
+	var cn *conn.Conn
+	select {
+	case cn = <-reconnected:
+	case <-time.After(time.Second * 3):
+		t.Fatal("reconnect timeout")
+	}
+	cancel()
+	wg.Wait()
+	l.Lock()
+	if len(attempts) == 0 || attempts[0] != 1 {
+		t.Errorf("attempts = %v", attempts)
+	}
+	l.Unlock()
+	if cn == nil || c.State() != client.StateRunning {
+		t.Fatalf("state = %v", c.State())
+	}
+	code, data, err := c.Request(context.Background(), []byte("hello"))
+	if err != nil || code != message.StateCode_Success || !bytes.Equal(data, []byte("hello")) {
+		t.Fatal(code, string(data), err)
+	}
+}
+
+func TestClientReconnectBackoff(t *testing.T) {
+	key := []byte("key")
+	s, addr := startServer(t, 1, server.WithAuthKey(key))
+	c := node.NewClientOption(10, 1, client.WithRemoteKey(key), client.WithReconnect(true), client.WithReconnectBackoff(time.Millisecond*20, time.Second, 2, 0), client.WithMaxReconnectAttempts(3))
+	var l sync.Mutex
+	var times []time.Time
+	c.AddOnReconnecting(func(attempt int, err error) bool {
+		l.Lock()
+		times = append(times, time.Now())
+		l.Unlock()
+		return true
+	})
+	if err := c.Connect(addr, nil); err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	_ = s.Close()
+	waitFor(t, time.Second*3, func() bool { return c.State() == client.StateClosed })
+	l.Lock()
+	defer l.Unlock()
+	if len(times) != 3 {
+		t.Fatalf("attempts = %d, want 3", len(times))
+	}
+	// 第n次重连前等待 20ms*2^(n-1)，三次共计至少140ms
+	if d := time.Since(start); d < time.Millisecond*140 {
+		t.Errorf("gave up after %v", d)
+	}
+	if d := times[2].Sub(times[1]); d < time.Millisecond*20 {
+		t.Errorf("second backoff %v", d)
+	}
+}
+
+// 连接建立前调用发送等方法返回 errors.ErrConnClosed 而不是panic
+func TestClientNotConnected(t *testing.T) {
+	c := node.NewClientOption(10, 1)
+	if err := c.Send([]byte("x")); err == nil || err.Error() != errors.ErrConnClosed.Error() {
+		t.Fatalf("Send err = %v, want %v", err, errors.ErrConnClosed)
+	}
+	if _, _, err := c.Request(context.Background(), []byte("x")); err == nil || err.Error() != errors.ErrConnClosed.Error() {
+		t.Fatalf("Request err = %v, want %v", err, errors.ErrConnClosed)
+	}
+	if _, err := c.OpenStream(context.Background()); err == nil || err.Error() != errors.ErrConnClosed.Error() {
+		t.Fatalf("OpenStream err = %v, want %v", err, errors.ErrConnClosed)
+	}
+	if c.LocalAddr() != nil || c.RemoteAddr() != nil {
+		t.Fatal("addr of unconnected client is not nil")
+	}
+	if m := c.CreateMessage(message.MsgType_Default, 10, 1, nil); m.Id == 0 {
+		t.Fatal("CreateMessage returned zero id")
+	}
+}
@@ -0,0 +1,73 @@
+package tests
+
+import (
+	"github.com/Li-giegie/node"
+	"github.com/Li-giegie/node/pkg/auth"
+	"github.com/Li-giegie/node/pkg/client"
+	"github.com/Li-giegie/node/pkg/conn"
+	"github.com/Li-giegie/node/pkg/server"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestServerRevoke(t *testing.T) {
+	store := auth.NewMemoryStore()
+	store.Set(10, []byte("key"))
+	s, addr := startServer(t, 1, server.WithAuthenticator(auth.KeyStore(store)))
+	c := node.NewClientOption(10, 1, client.WithRemoteKey([]byte("key")))
+	if err := c.Connect(addr, nil); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	s.Revoke(10)
+	waitFor(t, time.Second, func() bool { return c.State() == client.StateClosed })
+	if _, ok := s.GetConn(10); ok {
+		t.Fatal("revoked connection still registered")
+	}
+	// 吊销后无法再通过认证
+	c = node.NewClientOption(10, 1, client.WithRemoteKey([]byte("key")))
+	if err := c.Connect(addr, nil); err == nil {
+		_ = c.Close()
+		t.Fatal("revoked node authenticated")
+	}
+}
+
+// blockingAuth 认证时阻塞直至release关闭，用于在认证期间吊销
+type blockingAuth struct {
+	entered chan struct{}
+	release chan struct{}
+	once    sync.Once
+}
+
+func (a *blockingAuth) Authenticate(info *auth.Info) (*conn.Identity, error) {
+	a.once.Do(func() { close(a.entered) })
+	<-a.release
+	return nil, nil
+}
+
+func (a *blockingAuth) Revoke(uint32) {}
+
+// 认证通过后、添加连接前被吊销的连接不能被添加
+func TestServerRevokeDuringHandshake(t *testing.T) {
+	a := &blockingAuth{entered: make(chan struct{}), release: make(chan struct{})}
+	s, addr := startServer(t, 1, server.WithAuthenticator(a))
+	c := node.NewClientOption(10, 1, client.WithAuthenticator(auth.AuthenticatorFunc(func(*auth.Info) (*conn.Identity, error) { return nil, nil })))
+	errCh := make(chan error, 1)
+	go func() { errCh <- c.Connect(addr, nil) }()
+	<-a.entered
+	s.Revoke(10)
+	close(a.release)
+	err := <-errCh
+	if err == nil {
+		_ = c.Close()
+		t.Fatal("connection revoked during handshake was accepted")
+	}
+	if !strings.Contains(err.Error(), "revoked") {
+		t.Fatalf("err = %v", err)
+	}
+	if _, ok := s.GetConn(10); ok {
+		t.Fatal("revoked connection registered")
+	}
+}
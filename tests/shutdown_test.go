@@ -0,0 +1,81 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"github.com/Li-giegie/node"
+	"github.com/Li-giegie/node/pkg/client"
+	"github.com/Li-giegie/node/pkg/errors"
+	"github.com/Li-giegie/node/pkg/message"
+	"github.com/Li-giegie/node/pkg/reply"
+	"testing"
+	"time"
+)
+
+func TestServerShutdown(t *testing.T) {
+	s, addr := startServer(t, 1)
+	started := make(chan struct{})
+	s.AddOnMessage(func(r *reply.Reply, m *message.Message) bool {
+		close(started)
+		time.Sleep(time.Millisecond * 200)
+		_ = r.Write(message.StateCode_Success, m.Data)
+		return true
+	})
+	c := node.NewClientOption(10, 1)
+	if err := c.Connect(addr, nil); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	type result struct {
+		code int16
+		data []byte
+		err  error
+	}
+	res := make(chan result, 1)
+	go func() {
+		code, data, err := c.Request(context.Background(), []byte("inflight"))
+		res <- result{code, data, err}
+	}()
+	<-started
+	done := make(chan error, 1)
+	go func() { done <- s.Shutdown(context.Background()) }()
+	// 收到GoAway后新的请求直接失败
+	waitFor(t, time.Second, func() bool { return c.Conn().IsGoAway() })
+	if _, _, err := c.Request(context.Background(), []byte("new")); err == nil || err.Error() != errors.ErrGoAway.Error() {
+		t.Fatalf("request after goaway: %v", err)
+	}
+	// 正在处理的请求完成并写出响应后才关闭连接
+	r := <-res
+	if r.err != nil || r.code != message.StateCode_Success || !bytes.Equal(r.data, []byte("inflight")) {
+		t.Fatal(r.code, string(r.data), r.err)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, time.Second, func() bool { return len(s.GetAllConn()) == 0 })
+}
+
+func TestServerShutdownTimeout(t *testing.T) {
+	s, addr := startServer(t, 1)
+	started := make(chan struct{})
+	release := make(chan struct{})
+	defer close(release)
+	s.AddOnMessage(func(r *reply.Reply, m *message.Message) bool {
+		close(started)
+		<-release
+		return true
+	})
+	c := node.NewClientOption(10, 1)
+	if err := c.Connect(addr, nil); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	go func() { _, _, _ = c.Request(context.Background(), nil) }()
+	<-started
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*100)
+	defer cancel()
+	if err := s.Shutdown(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("shutdown = %v", err)
+	}
+	waitFor(t, time.Second, func() bool { return c.State() == client.StateClosed })
+}
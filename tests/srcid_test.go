@@ -0,0 +1,81 @@
+package tests
+
+import (
+	"context"
+	"github.com/Li-giegie/node"
+	"github.com/Li-giegie/node/pkg/message"
+	"github.com/Li-giegie/node/pkg/reply"
+	"github.com/Li-giegie/node/pkg/server"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestServerSrcIdCheck(t *testing.T) {
+	var handled int64
+	s, addr := startServer(t, 1)
+	s.AddOnMessage(func(r *reply.Reply, m *message.Message) bool {
+		atomic.AddInt64(&handled, 1)
+		return echo(r, m)
+	})
+	c := node.NewClientOption(10, 1)
+	if err := c.Connect(addr, nil); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	// 默认 server.SrcIdCheckClient，客户端连接上伪造源节点Id的消息被丢弃
+	if err := c.Conn().SendMessage(&message.Message{Id: 1, SrcId: 99, DestId: 1, Data: []byte("spoofed")}); err != nil {
+		t.Fatal(err)
+	}
+	// 同一连接上的消息按顺序处理，收到响应时伪造的消息已被处理
+	if code, _, err := c.Request(context.Background(), []byte("hello")); err != nil || code != message.StateCode_Success {
+		t.Fatal(code, err)
+	}
+	if n := atomic.LoadInt64(&handled); n != 1 {
+		t.Fatalf("handled %d messages, want 1", n)
+	}
+	if n := s.SrcIdViolations(); n != 1 {
+		t.Fatalf("violations = %d", n)
+	}
+}
+
+func TestServerSrcIdCheckClose(t *testing.T) {
+	s, addr := startServer(t, 1, server.WithSrcIdCheck(server.SrcIdCheckClient, true))
+	s.AddOnMessage(echo)
+	c := node.NewClientOption(10, 1)
+	if err := c.Connect(addr, nil); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if err := c.Conn().SendMessage(&message.Message{Id: 1, SrcId: 99, DestId: 1, Data: []byte("spoofed")}); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, time.Second, func() bool {
+		_, ok := s.GetConn(10)
+		return !ok
+	})
+	if n := s.SrcIdViolations(); n != 1 {
+		t.Fatalf("violations = %d", n)
+	}
+}
+
+func TestServerSrcIdCheckOff(t *testing.T) {
+	var src uint32
+	s, addr := startServer(t, 1, server.WithSrcIdCheck(server.SrcIdCheckOff, true))
+	s.AddOnMessage(func(r *reply.Reply, m *message.Message) bool {
+		atomic.StoreUint32(&src, m.SrcId)
+		return true
+	})
+	c := node.NewClientOption(10, 1)
+	if err := c.Connect(addr, nil); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if err := c.Conn().SendMessage(&message.Message{Id: 1, SrcId: 99, DestId: 1, Data: []byte("spoofed")}); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, time.Second, func() bool { return atomic.LoadUint32(&src) == 99 })
+	if _, ok := s.GetConn(10); !ok || s.SrcIdViolations() != 0 {
+		t.Fatal("unexpected violation")
+	}
+}
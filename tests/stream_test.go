@@ -0,0 +1,171 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"github.com/Li-giegie/node"
+	"github.com/Li-giegie/node/pkg/acl"
+	"github.com/Li-giegie/node/pkg/client"
+	"github.com/Li-giegie/node/pkg/errors"
+	"github.com/Li-giegie/node/pkg/message"
+	"github.com/Li-giegie/node/pkg/server"
+	"github.com/Li-giegie/node/pkg/stream"
+	"io"
+	"testing"
+	"time"
+)
+
+type streamResult struct {
+	data []byte
+	err  error
+}
+
+// slowReadStream 每次读取少量数据并等待，使数据在接收方缓存，读取完毕后关闭流
+func slowReadStream(s *stream.Stream, resC chan<- streamResult) {
+	defer s.Close()
+	var out bytes.Buffer
+	buf := make([]byte, 1024)
+	for {
+		time.Sleep(time.Millisecond)
+		n, err := s.Read(buf)
+		out.Write(buf[:n])
+		if err != nil {
+			if err == io.EOF {
+				err = nil
+			}
+			resC <- streamResult{out.Bytes(), err}
+			return
+		}
+	}
+}
+
+// copyClose 按常见的用法写入全部数据后直接关闭流
+func copyClose(t *testing.T, s *stream.Stream, resC <-chan streamResult) {
+	t.Helper()
+	data := bytes.Repeat([]byte("0123456789"), 10000)
+	if _, err := io.Copy(s, bytes.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case r := <-resC:
+		if r.err != nil || !bytes.Equal(r.data, data) {
+			t.Fatalf("received %d bytes, err = %v", len(r.data), r.err)
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatal("timeout")
+	}
+}
+
+func TestStreamCopyClose(t *testing.T) {
+	resC := make(chan streamResult, 1)
+	s, addr := startServer(t, 1, server.WithStreamWindow(16*1024))
+	s.SetOnStream(func(st *stream.Stream) { slowReadStream(st, resC) })
+	c := node.NewClientOption(10, 1)
+	if err := c.Connect(addr, nil); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	st, err := c.OpenStream(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	copyClose(t, st, resC)
+}
+
+// 经过服务端转发的流
+func TestStreamCopyCloseForward(t *testing.T) {
+	resC := make(chan streamResult, 1)
+	_, addr := startServer(t, 1)
+	c10 := node.NewClientOption(10, 1)
+	c11 := node.NewClientOption(11, 1, client.WithStreamWindow(16*1024))
+	c11.SetOnStream(func(st *stream.Stream) { slowReadStream(st, resC) })
+	for _, c := range []node.Client{c10, c11} {
+		if err := c.Connect(addr, nil); err != nil {
+			t.Fatal(err)
+		}
+		defer c.Close()
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	st, err := c10.OpenStreamTo(ctx, 11)
+	if err != nil {
+		t.Fatal(err)
+	}
+	copyClose(t, st, resC)
+}
+
+// 只允许10向11发起流时，11发出的数据和窗口更新不应被拒绝
+func TestStreamACL(t *testing.T) {
+	policy := acl.NewRules(false, acl.Rule{Src: []uint32{10}, Dst: []uint32{11}, Types: []uint8{message.MsgType_Stream}, Allow: true})
+	_, addr := startServer(t, 1, server.WithACL(policy))
+	c10 := node.NewClientOption(10, 1, client.WithStreamWindow(4096))
+	c11 := node.NewClientOption(11, 1, client.WithStreamWindow(4096))
+	c11.SetOnStream(func(st *stream.Stream) {
+		defer st.Close()
+		_, _ = io.Copy(st, st)
+	})
+	for _, c := range []node.Client{c10, c11} {
+		if err := c.Connect(addr, nil); err != nil {
+			t.Fatal(err)
+		}
+		defer c.Close()
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	st, err := c10.OpenStreamTo(ctx, 11)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := bytes.Repeat([]byte("0123456789"), 5000)
+	go func() {
+		_, _ = st.Write(data)
+		_ = st.CloseWrite()
+	}()
+	out := make(chan []byte, 1)
+	go func() {
+		b, _ := io.ReadAll(st)
+		out <- b
+	}()
+	select {
+	case b := <-out:
+		if !bytes.Equal(b, data) {
+			t.Fatalf("echo %d bytes", len(b))
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatal("stream stalled")
+	}
+}
+
+// 对端不再发送任何帧时（例如转发路径中断）流在空闲超时后结束
+func TestStreamIdleTimeout(t *testing.T) {
+	_, addr := startServer(t, 1)
+	c10 := node.NewClientOption(10, 1, client.WithStreamIdleTimeout(time.Millisecond*100))
+	c11 := node.NewClientOption(11, 1, client.WithStreamIdleTimeout(-1))
+	hold := make(chan struct{})
+	defer close(hold)
+	c11.SetOnStream(func(st *stream.Stream) { <-hold })
+	for _, c := range []node.Client{c10, c11} {
+		if err := c.Connect(addr, nil); err != nil {
+			t.Fatal(err)
+		}
+		defer c.Close()
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	st, err := c10.OpenStreamTo(ctx, 11)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	if _, err = st.Read(make([]byte, 1)); err == nil || err.Error() != errors.ErrStreamTimeout.Error() {
+		t.Fatalf("err = %v", err)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Fatalf("expired after %v", d)
+	}
+}